@@ -0,0 +1,336 @@
+package easyframework
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const BUCKET_API_KEYS BucketID = "ef_api_keys"
+
+// API keys look like "efk_<key id>_<secret>", "efk_<key id>" is the prefix, it is not secret and is used for the lookup
+const API_KEY_TOKEN_PREFIX = "efk_"
+
+const PERMISSION_API_KEYS_ADMIN = "api_keys.admin"
+
+type APIKey struct {
+	ID         ID128    `id:"1"`
+	Name       string   `id:"2"`
+	Hash       [32]byte `id:"3" json:"-"` // sha256 of the secret, secrets are random enough not to need a slow hash
+	Scopes     []string `id:"4"`
+	OwnerID    ID[User] `id:"5"`
+	CreatedAt  int64    `id:"6"`
+	ExpiresAt  int64    `id:"7"` // Zero means the key never expires
+	LastUsedAt int64    `id:"8"`
+}
+
+func (key *APIKey) Prefix() string {
+	return API_KEY_TOKEN_PREFIX + key.ID.String()
+}
+
+type APIKeyParams struct {
+	Header          string // Header that can carry the key instead of Authorization: Bearer, default is "X-API-Key"
+	AdminProcedures bool   // Register ApiKeys.Create/List/Revoke, they require PERMISSION_API_KEYS_ADMIN
+}
+
+type APIKeyNotFoundError struct{}
+
+func (v APIKeyNotFoundError) Error() string {
+	return "API key not found"
+}
+
+type APIKeyExpiredError struct{}
+
+func (v APIKeyExpiredError) Error() string {
+	return "API key expired"
+}
+
+func InitializeAPIKeys(ctx *Context, params APIKeyParams) error {
+	if params.Header == "" {
+		params.Header = "X-API-Key"
+	}
+
+	err := NewBucket(ctx, BUCKET_API_KEYS)
+	if err != nil {
+		return err
+	}
+
+	ctx.APIKeys = &params
+
+	if ctx.Authorization != nil {
+		ctx.Authorization = AnyAuthorization(APIKeyAuthorization, ctx.Authorization)
+	} else {
+		ctx.Authorization = APIKeyAuthorization
+	}
+
+	if params.AdminProcedures {
+		RegisterAPIKeyProcedures(ctx)
+	}
+
+	return nil
+}
+
+// AnyAuthorization passes if any of the authorization procedures passes, they are tried in order
+func AnyAuthorization(authorizations ...func(*RequestContext, http.ResponseWriter, *http.Request) bool) func(*RequestContext, http.ResponseWriter, *http.Request) bool {
+	return func(requestContext *RequestContext, w http.ResponseWriter, r *http.Request) bool {
+		for _, authorization := range authorizations {
+			if authorization(requestContext, w, r) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+type NewAPIKeyParams struct {
+	Name     string
+	Scopes   []string
+	OwnerID  ID[User]
+	Lifetime time.Duration // Zero means the key never expires
+}
+
+// CreateAPIKey stores a new key and returns the token, the token is not stored anywhere and can't be recovered later
+func CreateAPIKey(ctx *Context, params NewAPIKeyParams) (token string, key APIKey, err error) {
+	var secret [32]byte
+	_, err = rand.Read(secret[:])
+	if err != nil {
+		return "", key, err
+	}
+	secretString := base64.RawURLEncoding.EncodeToString(secret[:])
+
+	now := time.Now()
+	key = APIKey{
+		ID:        NewID128(),
+		Name:      params.Name,
+		Hash:      sha256.Sum256([]byte(secretString)),
+		Scopes:    params.Scopes,
+		OwnerID:   params.OwnerID,
+		CreatedAt: now.Unix(),
+	}
+	if params.Lifetime > 0 {
+		key.ExpiresAt = now.Add(params.Lifetime).Unix()
+	}
+
+	err = InsertByID(ctx, BUCKET_API_KEYS, key.ID, &key)
+	if err != nil {
+		return "", key, err
+	}
+
+	return key.Prefix() + "_" + secretString, key, nil
+}
+
+func ListAPIKeys(ctx *Context) (keys []APIKey, err error) {
+	err = ctx.Database.View(func(tx Tx) error {
+		bucket, err := GetBucket(tx, BUCKET_API_KEYS)
+		if err != nil {
+			return err
+		}
+
+		keys = IterateCollectAll[APIKey](bucket)
+		return nil
+	})
+
+	return keys, err
+}
+
+func RevokeAPIKey(ctx *Context, keyID ID128) error {
+	return ctx.Database.Update(func(tx Tx) error {
+		bucket, err := GetBucket(tx, BUCKET_API_KEYS)
+		if err != nil {
+			return err
+		}
+
+		if bucket.Get(keyID[:]) == nil {
+			return APIKeyNotFoundError{}
+		}
+		return Delete[APIKey](bucket, keyID)
+	})
+}
+
+// LookupAPIKey finds the key by the prefix of the token and checks the secret and expiry
+func LookupAPIKey(ctx *Context, token string) (APIKey, error) {
+	var key APIKey
+
+	if !strings.HasPrefix(token, API_KEY_TOKEN_PREFIX) {
+		return key, APIKeyNotFoundError{}
+	}
+	parts := strings.SplitN(token[len(API_KEY_TOKEN_PREFIX):], "_", 2)
+	if len(parts) != 2 {
+		return key, APIKeyNotFoundError{}
+	}
+
+	var keyID ID128
+	if keyID.FromString(parts[0]) != nil {
+		return key, APIKeyNotFoundError{}
+	}
+
+	if !GetByID(ctx, BUCKET_API_KEYS, keyID, &key) {
+		return key, APIKeyNotFoundError{}
+	}
+
+	hash := sha256.Sum256([]byte(parts[1]))
+	if subtle.ConstantTimeCompare(hash[:], key.Hash[:]) != 1 {
+		return key, APIKeyNotFoundError{}
+	}
+
+	if key.ExpiresAt != 0 && key.ExpiresAt <= time.Now().Unix() {
+		return key, APIKeyExpiredError{}
+	}
+
+	return key, nil
+}
+
+func touchAPIKey(ctx *Context, keyID ID128) error {
+	return ctx.Database.Batch(func(tx Tx) error {
+		bucket, err := GetBucket(tx, BUCKET_API_KEYS)
+		if err != nil {
+			return err
+		}
+
+		data := bucket.Get(keyID[:])
+		if data == nil {
+			return nil // Revoked in the meantime
+		}
+
+		var key APIKey
+		err = Unpack(data, &key)
+		if err != nil {
+			return err
+		}
+		key.LastUsedAt = time.Now().Unix()
+
+		return Insert(bucket, keyID, &key)
+	})
+}
+
+func APIKeyTokenFromRequest(ctx *Context, r *http.Request) string {
+	authorization := r.Header.Get("Authorization")
+	if strings.HasPrefix(authorization, "Bearer "+API_KEY_TOKEN_PREFIX) {
+		return strings.TrimSpace(authorization[len("Bearer "):])
+	}
+
+	return r.Header.Get(ctx.APIKeys.Header)
+}
+
+// APIKeyAuthorization accepts requests that carry a valid API key. The key scopes, limited to what the owner still has,
+// become the permissions of the caller.
+func APIKeyAuthorization(requestContext *RequestContext, w http.ResponseWriter, r *http.Request) bool {
+	ctx := requestContext.Context
+
+	token := APIKeyTokenFromRequest(ctx, r)
+	if token == "" {
+		return false
+	}
+
+	key, err := LookupAPIKey(ctx, token)
+	if err != nil {
+		return false
+	}
+
+	if time.Now().Unix()-key.LastUsedAt >= 60 { // Don't write on every single request
+		err := touchAPIKey(ctx, key.ID)
+		if err != nil {
+			log.Printf("Failed to update API key last use: %v", err)
+		}
+	}
+
+	requestContext.APIKey = &key
+	requestContext.UserID = key.OwnerID
+	requestContext.Credential = CREDENTIAL_HEADER
+
+	return true
+}
+
+type CreateAPIKeyRequest struct {
+	Name            string   `tag:"required"`
+	Scopes          []string `description:"permissions the key grants"`
+	LifetimeSeconds int64    `description:"zero means the key never expires"`
+}
+
+type CreateAPIKeyResponse struct {
+	Token string `description:"shown only once"`
+	Key   APIKey
+}
+
+type RevokeAPIKeyRequest struct {
+	ID ID128 `tag:"required"`
+}
+
+func RPC_CreateAPIKey(requestContext *RequestContext, request CreateAPIKeyRequest) (response CreateAPIKeyResponse, problem Problem) {
+	for _, scope := range request.Scopes { // A key can't grant more than its creator has
+		if requestContext.Principal == nil || !HasPermission(requestContext.Context, requestContext.Principal, scope) {
+			problem.ErrorID = ERROR_FORBIDDEN
+			problem.Message = fmt.Sprintf("Scope %v is not granted to the caller", scope)
+			return
+		}
+	}
+
+	token, key, err := CreateAPIKey(requestContext.Context, NewAPIKeyParams{
+		Name:     request.Name,
+		Scopes:   request.Scopes,
+		OwnerID:  requestContext.UserID,
+		Lifetime: time.Duration(request.LifetimeSeconds) * time.Second,
+	})
+	if err != nil {
+		log.Printf("Failed to create API key: %v", err)
+		problem.ErrorID = ERROR_INTERNAL
+		return
+	}
+
+	response.Token = token
+	response.Key = key
+	return
+}
+
+func RPC_ListAPIKeys(requestContext *RequestContext) (keys []APIKey, problem Problem) {
+	keys, err := ListAPIKeys(requestContext.Context)
+	if err != nil {
+		log.Printf("Failed to list API keys: %v", err)
+		problem.ErrorID = ERROR_INTERNAL
+	}
+	return
+}
+
+func RPC_RevokeAPIKey(requestContext *RequestContext, request RevokeAPIKeyRequest) (problem Problem) {
+	err := RevokeAPIKey(requestContext.Context, request.ID)
+	if _, notFound := err.(APIKeyNotFoundError); notFound {
+		problem.ErrorID = ERROR_API_KEY_NOT_FOUND
+		problem.Message = err.Error()
+	} else if err != nil {
+		log.Printf("Failed to revoke API key: %v", err)
+		problem.ErrorID = ERROR_INTERNAL
+	}
+	return
+}
+
+func RegisterAPIKeyProcedures(ctx *Context) {
+	NewRPC(ctx, NewRPCParams{
+		Name:        "ApiKeys.Create",
+		Handler:     RPC_CreateAPIKey,
+		Category:    "API keys",
+		Description: "Create an API key owned by the caller",
+		Permissions: []string{PERMISSION_API_KEYS_ADMIN},
+	})
+
+	NewRPC(ctx, NewRPCParams{
+		Name:        "ApiKeys.List",
+		Handler:     RPC_ListAPIKeys,
+		Category:    "API keys",
+		Description: "List all API keys",
+		Permissions: []string{PERMISSION_API_KEYS_ADMIN},
+	})
+
+	NewRPC(ctx, NewRPCParams{
+		Name:        "ApiKeys.Revoke",
+		Handler:     RPC_RevokeAPIKey,
+		Category:    "API keys",
+		Description: "Revoke an API key",
+		Permissions: []string{PERMISSION_API_KEYS_ADMIN},
+	})
+}
@@ -0,0 +1,70 @@
+package easyframework
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIKeyScopesFollowTheOwner(t *testing.T) {
+	owner := NewID[User]()
+	ownerPermissions := []string{"reports.read", "reports.write"}
+
+	ctx := new(Context)
+	err := Initialize(ctx, InitializeParams{
+		Storage: NewMemoryStorage(),
+		APIKeys: &APIKeyParams{AdminProcedures: true},
+		PermissionResolver: func(requestContext *RequestContext) (Principal, error) {
+			if requestContext.UserID != owner {
+				return Principal{}, nil
+			}
+			return Principal{Permissions: ownerPermissions}, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+
+	token, _, err := CreateAPIKey(ctx, NewAPIKeyParams{Name: "reports", Scopes: []string{"reports.read", "reports.write"}, OwnerID: owner})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	allowed := func(permission string) bool {
+		request := httptest.NewRequest("POST", "/rpc/Test", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		requestContext := &RequestContext{Context: ctx, Procedure: &Procedure{Permissions: []string{permission}}, Request: request}
+		if !ctx.Authorization(requestContext, httptest.NewRecorder(), request) {
+			t.Fatalf("Key was not accepted")
+		}
+		_, ok := CheckProcedurePermissions(ctx, requestContext)
+		return ok
+	}
+
+	if !allowed("reports.write") {
+		t.Fatalf("Scope the owner has was denied")
+	}
+	if allowed("users.write") {
+		t.Fatalf("Permission outside the key scopes was granted")
+	}
+
+	ownerPermissions = []string{"reports.read"} // Demoted
+	if allowed("reports.write") {
+		t.Fatalf("Key kept a scope its owner lost")
+	}
+	if !allowed("reports.read") {
+		t.Fatalf("Scope the owner still has was denied")
+	}
+}
+
+func TestRevokeMissingAPIKey(t *testing.T) {
+	ctx := new(Context)
+	err := Initialize(ctx, InitializeParams{Storage: NewMemoryStorage(), APIKeys: &APIKeyParams{}})
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+
+	problem := RPC_RevokeAPIKey(&RequestContext{Context: ctx}, RevokeAPIKeyRequest{ID: NewID128()})
+	if problem.ErrorID != ERROR_API_KEY_NOT_FOUND {
+		t.Fatalf("Expected %v, got %v", ERROR_API_KEY_NOT_FOUND, problem.ErrorID)
+	}
+}
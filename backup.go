@@ -0,0 +1,312 @@
+package easyframework
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const PERMISSION_BACKUP_ADMIN = "backup.admin"
+
+const SNAPSHOT_PREFIX = "snapshot_"
+const SNAPSHOT_SUFFIX = ".db"
+
+type BackupParams struct {
+	Directory      string        // Where snapshots are written, default is "snapshots"
+	Period         time.Duration // How often a snapshot is taken, zero means only on demand
+	Keep           int           // Snapshots to keep, older ones are removed. Default is 7.
+	AdminProcedure bool          // Register Backup.Download, it requires PERMISSION_BACKUP_ADMIN
+}
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "restore",
+		Usage:       "<snapshot>",
+		Description: "Validate the snapshot and replace the database with it, the server must be stopped",
+		Run: func(params InitializeParams, args []string) error {
+			if len(args) != 1 {
+				return errors.New("Usage: restore <snapshot>")
+			}
+			if params.DatabasePath == "" {
+				return errors.New("restore requires DatabasePath")
+			}
+			return RestoreSnapshot(params.DatabasePath, args[0])
+		},
+	})
+}
+
+func InitializeBackups(ctx *Context, params BackupParams) error {
+	if params.Directory == "" {
+		params.Directory = "snapshots"
+	}
+	if params.Keep == 0 {
+		params.Keep = 7
+	}
+
+	err := os.MkdirAll(params.Directory, 0777)
+	if err != nil {
+		return err
+	}
+
+	ctx.Backups = &params
+
+	if params.Period != 0 {
+		go SnapshotRoutine(ctx)
+	}
+
+	if params.AdminProcedure {
+		NewRPC(ctx, NewRPCParams{
+			Name:           "Backup.Download",
+			Handler:        RPC_DownloadBackup,
+			Category:       "Backup",
+			Description:    "Stream a consistent snapshot of the database",
+			Permissions:    []string{PERMISSION_BACKUP_ADMIN},
+			CustomResponse: true,
+		})
+	}
+
+	return nil
+}
+
+/*
+Backup writes a consistent copy of the database while the server keeps running. Readers are not blocked, writers
+mostly aren't: on bolt a commit that has to grow the database file waits until the copy is done. Write to something
+fast, like a local file, not straight to a client.
+*/
+func Backup(ctx *Context, w io.Writer) (size int64, err error) {
+	err = ctx.Database.View(func(tx Tx) error {
+		snapshotTx, ok := tx.(SnapshotTx)
+		if !ok {
+			return StorageNotSupportedError{Feature: "snapshots"}
+		}
+		size, err = snapshotTx.WriteTo(w)
+		return err
+	})
+	return size, err
+}
+
+// TakeSnapshot writes a snapshot to the backup directory and removes the ones above Keep
+func TakeSnapshot(ctx *Context) (path string, err error) {
+	if ctx.Backups == nil {
+		return "", errors.New("Backups are not initialized, see InitializeParams.Backups")
+	}
+
+	name := SNAPSHOT_PREFIX + time.Now().UTC().Format("2006-01-02T15-04-05.000") + SNAPSHOT_SUFFIX
+	path = filepath.Join(ctx.Backups.Directory, name)
+	temporaryPath := path + ".tmp" // Not listed as a snapshot until it is complete
+
+	file, err := os.Create(temporaryPath)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = Backup(ctx, file)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temporaryPath, path)
+	}
+	if err != nil {
+		os.Remove(temporaryPath)
+		return "", err
+	}
+
+	return path, PruneSnapshots(ctx.Backups.Directory, ctx.Backups.Keep)
+}
+
+// ListSnapshots returns snapshot paths in the directory, oldest first
+func ListSnapshots(directory string) ([]string, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, SNAPSHOT_PREFIX) && strings.HasSuffix(name, SNAPSHOT_SUFFIX) {
+			paths = append(paths, filepath.Join(directory, name))
+		}
+	}
+	sort.Strings(paths) // Names are timestamps
+	return paths, nil
+}
+
+func PruneSnapshots(directory string, keep int) error {
+	paths, err := ListSnapshots(directory)
+	if err != nil {
+		return err
+	}
+
+	for len(paths) > keep {
+		err := os.Remove(paths[0])
+		if err != nil {
+			return err
+		}
+		log.Printf("Removed old snapshot %v", paths[0])
+		paths = paths[1:]
+	}
+
+	return nil
+}
+
+func SnapshotRoutine(ctx *Context) {
+	for {
+		time.Sleep(ctx.Backups.Period)
+
+		start := time.Now()
+		path, err := TakeSnapshot(ctx)
+		if err != nil {
+			log.Printf("Snapshot FAILED: %v", err)
+		} else {
+			log.Printf("Snapshot %v taken in %v", path, time.Since(start))
+		}
+	}
+}
+
+// ValidateSnapshot opens the snapshot read-only and checks consistency of every page, and that every bucket can be read
+func ValidateSnapshot(path string) error {
+	database, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("Snapshot %v can't be opened: %w", path, err)
+	}
+	defer database.Close()
+
+	return database.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			return fmt.Errorf("Snapshot %v is corrupted: %w", path, err)
+		}
+
+		return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			return bucket.ForEach(func(key, value []byte) error {
+				return nil
+			})
+		})
+	})
+}
+
+/*
+RestoreSnapshot replaces the database with the snapshot. The current database is kept next to it as
+<databasePath>.before_restore_<time>. Fails if the database is in use, the server must be stopped.
+*/
+func RestoreSnapshot(databasePath string, snapshotPath string) error {
+	err := ValidateSnapshot(snapshotPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stat(databasePath)
+	databaseExists := err == nil
+	if databaseExists { // Bolt locks the file while it is open
+		database, err := bolt.Open(databasePath, 0600, &bolt.Options{Timeout: time.Second})
+		if err != nil {
+			return fmt.Errorf("Database %v is in use, stop the server first: %w", databasePath, err)
+		}
+		database.Close()
+	}
+
+	restoringPath := databasePath + ".restoring"
+	err = copyFile(snapshotPath, restoringPath)
+	if err != nil {
+		os.Remove(restoringPath)
+		return err
+	}
+
+	if databaseExists {
+		previousPath := databasePath + ".before_restore_" + strconv.FormatInt(time.Now().Unix(), 10)
+		err = os.Rename(databasePath, previousPath)
+		if err != nil {
+			os.Remove(restoringPath)
+			return err
+		}
+		log.Printf("Previous database moved to %v", previousPath)
+	}
+
+	err = os.Rename(restoringPath, databasePath)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Database %v restored from %v", databasePath, snapshotPath)
+	return nil
+}
+
+func copyFile(from, to string) error {
+	source, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	destination, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(destination, source)
+	if err == nil {
+		err = destination.Sync()
+	}
+	closeErr := destination.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// RPC_DownloadBackup copies the database to a temporary file first, the download doesn't hold a transaction open
+func RPC_DownloadBackup(requestContext *RequestContext) (problem Problem) {
+	ctx := requestContext.Context
+	writer := requestContext.ResponseWriter
+
+	file, err := os.CreateTemp(ctx.Backups.Directory, "download_*.tmp")
+	if err != nil {
+		log.Printf("Backup download FAILED: %v", err)
+		problem.ErrorID = ERROR_INTERNAL
+		return
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	size, err := Backup(ctx, file)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	var notSupported StorageNotSupportedError
+	if errors.As(err, &notSupported) {
+		http.Error(writer, "Storage doesn't support snapshots", http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		log.Printf("Backup download FAILED: %v", err)
+		problem.ErrorID = ERROR_INTERNAL
+		return
+	}
+
+	name := SNAPSHOT_PREFIX + time.Now().UTC().Format("2006-01-02T15-04-05") + SNAPSHOT_SUFFIX
+	writer.Header().Set("Content-Type", "application/octet-stream")
+	writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	writer.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	writer.WriteHeader(http.StatusOK)
+
+	_, err = io.Copy(writer, file)
+	if err != nil { // Headers are already sent, the client sees a truncated body
+		log.Printf("Backup download FAILED: %v", err)
+	}
+	return
+}
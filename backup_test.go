@@ -0,0 +1,73 @@
+package easyframework
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestTakeSnapshotWithoutBackups(t *testing.T) {
+	ctx := new(Context)
+	_, err := TakeSnapshot(ctx)
+	if err == nil {
+		t.Fatalf("TakeSnapshot without Backups succeeded")
+	}
+}
+
+func TestSnapshotAndDownload(t *testing.T) {
+	directory := t.TempDir()
+	storage, err := OpenBoltStorage(filepath.Join(directory, "test.db"), 0600, &bolt.Options{InitialMmapSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("OpenBoltStorage: %v", err)
+	}
+	defer storage.Close()
+	putTestValues(t, storage, "items", map[string]string{"a": "1"})
+
+	ctx := &Context{Database: storage}
+	err = InitializeBackups(ctx, BackupParams{Directory: filepath.Join(directory, "snapshots"), Keep: 1})
+	if err != nil {
+		t.Fatalf("InitializeBackups: %v", err)
+	}
+
+	var path string
+	for i := 0; i < 2; i += 1 {
+		path, err = TakeSnapshot(ctx)
+		if err != nil {
+			t.Fatalf("TakeSnapshot: %v", err)
+		}
+	}
+	err = ValidateSnapshot(path)
+	if err != nil {
+		t.Fatalf("ValidateSnapshot: %v", err)
+	}
+	if paths, _ := ListSnapshots(ctx.Backups.Directory); len(paths) != 1 || paths[0] != path {
+		t.Fatalf("Expected only the last snapshot to be kept, got %v", paths)
+	}
+
+	recorder := httptest.NewRecorder()
+	problem := RPC_DownloadBackup(&RequestContext{Context: ctx, ResponseWriter: recorder})
+	if problem.ErrorID != "" || recorder.Code != http.StatusOK {
+		t.Fatalf("Download failed: %v %v", problem.ErrorID, recorder.Code)
+	}
+	if recorder.Header().Get("Content-Length") != strconv.Itoa(recorder.Body.Len()) {
+		t.Fatalf("Content-Length %v doesn't match the body of %v bytes", recorder.Header().Get("Content-Length"), recorder.Body.Len())
+	}
+	downloaded := filepath.Join(directory, "downloaded.db")
+	err = os.WriteFile(downloaded, recorder.Body.Bytes(), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ValidateSnapshot(downloaded)
+	if err != nil {
+		t.Fatalf("Downloaded snapshot is broken: %v", err)
+	}
+
+	if paths, _ := filepath.Glob(filepath.Join(ctx.Backups.Directory, "*.tmp")); len(paths) != 0 {
+		t.Fatalf("Temporary files are left behind: %v", paths)
+	}
+}
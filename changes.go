@@ -0,0 +1,471 @@
+package easyframework
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+Changes made through Insert, Delete and IterateRemove are delivered to subscribers after the transaction commits.
+Rolled back transactions deliver nothing. Writes that go around them (bucket.Put) are not seen.
+
+With the change log enabled for a bucket, every change is also stored in BUCKET_CHANGES in the same transaction,
+keyed by a sequence number, so a subscriber that was down can resume with SubscribeFrom.
+*/
+
+const BUCKET_CHANGES BucketID = "ef_changes"
+
+const CHANGE_DELIVERY_BATCH = 1000 // Changes SubscribeFrom reads in one transaction
+
+type ChangeOp int8
+
+const (
+	CHANGE_INSERT ChangeOp = iota + 1
+	CHANGE_UPDATE
+	CHANGE_DELETE
+)
+
+func (op ChangeOp) String() string {
+	switch op {
+	case CHANGE_INSERT:
+		return "insert"
+	case CHANGE_UPDATE:
+		return "update"
+	case CHANGE_DELETE:
+		return "delete"
+	}
+	return "unknown"
+}
+
+type Change[T any] struct {
+	Sequence int64 // Position in the change log, zero if the log is not enabled for the bucket
+	Bucket   BucketID
+	ID       ID128
+	Op       ChangeOp
+	Old      *T // nil for inserts
+	New      *T // nil for deletes
+}
+
+type changeEvent struct {
+	Sequence int64
+	Bucket   BucketID
+	ID       ID128
+	Op       ChangeOp
+	Old      interface{}
+	New      interface{}
+}
+
+type changeSubscriber struct {
+	ID      int64
+	Bucket  BucketID
+	Deliver func(event changeEvent)
+}
+
+var changeFeedMutex sync.RWMutex
+var changeSubscribers = make(map[BucketID][]changeSubscriber)
+var changeLogBuckets = make(map[BucketID]bool)
+var changeWatched int64 // Buckets with subscribers or the change log, writes don't look up bucket names while it is zero
+var lastSubscriberID int64
+
+// Subscribe calls the handler after every committed change of the bucket. Handlers run on the committing goroutine, keep them short.
+func Subscribe[T any](bucketID BucketID, handler func(change Change[T])) (unsubscribe func()) {
+	return subscribe(bucketID, func(event changeEvent) {
+		handler(typedChange[T](event))
+	})
+}
+
+func subscribe(bucketID BucketID, deliver func(event changeEvent)) (unsubscribe func()) {
+	changeFeedMutex.Lock()
+	defer changeFeedMutex.Unlock()
+
+	lastSubscriberID += 1
+	subscriber := changeSubscriber{ID: lastSubscriberID, Bucket: bucketID, Deliver: deliver}
+	changeSubscribers[bucketID] = append(changeSubscribers[bucketID], subscriber)
+	updateChangeWatched()
+
+	return func() {
+		changeFeedMutex.Lock()
+		defer changeFeedMutex.Unlock()
+
+		subscribers := changeSubscribers[bucketID]
+		for i := range subscribers {
+			if subscribers[i].ID == subscriber.ID {
+				changeSubscribers[bucketID] = append(subscribers[:i:i], subscribers[i+1:]...)
+				break
+			}
+		}
+		if len(changeSubscribers[bucketID]) == 0 {
+			delete(changeSubscribers, bucketID)
+		}
+		updateChangeWatched()
+	}
+}
+
+// updateChangeWatched must be called with changeFeedMutex locked
+func updateChangeWatched() {
+	atomic.StoreInt64(&changeWatched, int64(len(changeSubscribers)+len(changeLogBuckets)))
+}
+
+func typedChange[T any](event changeEvent) Change[T] {
+	change := Change[T]{
+		Sequence: event.Sequence,
+		Bucket:   event.Bucket,
+		ID:       event.ID,
+		Op:       event.Op,
+	}
+	change.Old, _ = event.Old.(*T)
+	change.New, _ = event.New.(*T)
+	return change
+}
+
+// EnableChangeLog makes changes of the bucket durable, needed for SubscribeFrom and ReadChanges
+func EnableChangeLog(ctx *Context, bucketID BucketID) error {
+	err := NewBucket(ctx, BUCKET_CHANGES)
+	if err != nil {
+		return err
+	}
+
+	changeFeedMutex.Lock()
+	defer changeFeedMutex.Unlock()
+
+	changeLogBuckets[bucketID] = true
+	updateChangeWatched()
+	return nil
+}
+
+// BucketName is the name of a top level bucket, nested buckets (indexes and such) are not found
+func BucketName(bucket Bucket) (BucketID, bool) {
+	if bucket.Parent() != nil {
+		return "", false
+	}
+	name := bucket.Name()
+	return BucketID(name), len(name) != 0
+}
+
+// watchedBucket returns the name of the bucket if anyone is interested in its changes
+func watchedBucket(bucket Bucket) (BucketID, bool) {
+	if atomic.LoadInt64(&changeWatched) == 0 || !bucket.Writable() {
+		return "", false
+	}
+
+	name, found := BucketName(bucket)
+	if !found {
+		return "", false
+	}
+
+	changeFeedMutex.RLock()
+	defer changeFeedMutex.RUnlock()
+	return name, len(changeSubscribers[name]) > 0 || changeLogBuckets[name]
+}
+
+// recordChange writes the change log entry and delivers the change once the transaction commits
+func recordChange[T any](bucket Bucket, bucketID BucketID, ID ID128, oldValue, newValue *T) error {
+	event := changeEvent{
+		Bucket: bucketID,
+		ID:     ID,
+	}
+	switch {
+	case oldValue == nil && newValue == nil:
+		return nil
+	case oldValue == nil:
+		event.Op = CHANGE_INSERT
+	case newValue == nil:
+		event.Op = CHANGE_DELETE
+	default:
+		event.Op = CHANGE_UPDATE
+	}
+	if oldValue != nil {
+		event.Old = oldValue
+	}
+	if newValue != nil {
+		copied := *newValue // The caller may keep changing the value after Insert
+		event.New = &copied
+	}
+
+	changeFeedMutex.RLock()
+	logged := changeLogBuckets[bucketID]
+	changeFeedMutex.RUnlock()
+
+	tx := bucket.Tx()
+	if logged {
+		changes := tx.Bucket([]byte(BUCKET_CHANGES))
+		if changes == nil {
+			return BucketNotFoundError{}
+		}
+
+		sequence, err := changes.NextSequence()
+		if err != nil {
+			return err
+		}
+		event.Sequence = int64(sequence)
+
+		var oldData, newData []byte
+		if oldValue != nil {
+			oldData, err = Pack(oldValue)
+			if err != nil {
+				return err
+			}
+		}
+		if newValue != nil {
+			newData, err = Pack(newValue)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = changes.Put(changeLogKey(event.Sequence), encodeChangeLogEntry(event, oldData, newData))
+		if err != nil {
+			return err
+		}
+	}
+
+	tx.OnCommit(func() {
+		deliverChange(event)
+	})
+	return nil
+}
+
+func deliverChange(event changeEvent) {
+	changeFeedMutex.RLock()
+	subscribers := changeSubscribers[event.Bucket]
+	changeFeedMutex.RUnlock()
+
+	for _, subscriber := range subscribers {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("Change subscriber of %v panicked: %v", event.Bucket, r)
+				}
+			}()
+			subscriber.Deliver(event)
+		}()
+	}
+}
+
+func changeLogKey(sequence int64) []byte {
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], uint64(sequence))
+	return key[:]
+}
+
+/*
+Change log entry:
+
+	op (1), ID (16), bucket name length (2), bucket name, old record length (4), old record, new record
+
+Records are in the Pack format, empty when absent.
+*/
+func encodeChangeLogEntry(event changeEvent, oldData, newData []byte) []byte {
+	entry := make([]byte, 0, 1+16+2+len(event.Bucket)+4+len(oldData)+len(newData))
+	entry = append(entry, byte(event.Op))
+	entry = append(entry, event.ID[:]...)
+	entry = binary.BigEndian.AppendUint16(entry, uint16(len(event.Bucket)))
+	entry = append(entry, event.Bucket...)
+	entry = binary.BigEndian.AppendUint32(entry, uint32(len(oldData)))
+	entry = append(entry, oldData...)
+	entry = append(entry, newData...)
+	return entry
+}
+
+type changeLogEntry struct {
+	Op      ChangeOp
+	ID      ID128
+	Bucket  BucketID
+	OldData []byte
+	NewData []byte
+}
+
+func decodeChangeLogEntry(entry []byte) (result changeLogEntry, err error) {
+	if len(entry) < 1+16+2 {
+		return result, errors.New("Change log entry is too short")
+	}
+	result.Op = ChangeOp(entry[0])
+	result.ID = ID128(entry[1:17])
+	nameLength := int(binary.BigEndian.Uint16(entry[17:19]))
+	entry = entry[19:]
+	if len(entry) < nameLength+4 {
+		return result, errors.New("Change log entry is too short")
+	}
+	result.Bucket = BucketID(entry[:nameLength])
+	entry = entry[nameLength:]
+	oldLength := int(binary.BigEndian.Uint32(entry[:4]))
+	entry = entry[4:]
+	if len(entry) < oldLength {
+		return result, errors.New("Change log entry is too short")
+	}
+	result.OldData = entry[:oldLength]
+	result.NewData = entry[oldLength:]
+	return result, nil
+}
+
+func unpackChangeLogEntry[T any](sequence int64, entry changeLogEntry) (Change[T], error) {
+	change := Change[T]{
+		Sequence: sequence,
+		Bucket:   entry.Bucket,
+		ID:       entry.ID,
+		Op:       entry.Op,
+	}
+	if len(entry.OldData) > 0 {
+		change.Old = new(T)
+		err := Unpack(entry.OldData, change.Old)
+		if err != nil {
+			return change, err
+		}
+	}
+	if len(entry.NewData) > 0 {
+		change.New = new(T)
+		err := Unpack(entry.NewData, change.New)
+		if err != nil {
+			return change, err
+		}
+	}
+	return change, nil
+}
+
+// ReadChanges calls the procedure for logged changes of the bucket with a sequence above after, until it returns false
+func ReadChanges[T any](ctx *Context, bucketID BucketID, after int64, procedure func(change Change[T]) bool) error {
+	return ctx.Database.View(func(tx Tx) error {
+		changes, err := GetBucket(tx, BUCKET_CHANGES)
+		if err != nil {
+			return err
+		}
+
+		cursor := changes.Cursor()
+		for key, value := cursor.Seek(changeLogKey(after + 1)); key != nil; key, value = cursor.Next() {
+			sequence := int64(binary.BigEndian.Uint64(key))
+			entry, err := decodeChangeLogEntry(value)
+			if err != nil {
+				return fmt.Errorf("Change %v: %w", sequence, err)
+			}
+			if entry.Bucket != bucketID {
+				continue
+			}
+
+			change, err := unpackChangeLogEntry[T](sequence, entry)
+			if err != nil {
+				return fmt.Errorf("Change %v: %w", sequence, err)
+			}
+			if !procedure(change) {
+				break
+			}
+		}
+
+		return nil
+	})
+}
+
+/*
+SubscribeFrom replays logged changes after the sequence, then continues with live ones. Nothing is missed or delivered
+twice and changes come in sequence order.
+Live changes only wake the subscriber up, it reads them from the log. Commits take sequences one at a time, so once a
+change is delivered every change before it is committed and readable, even if its own delivery is still on the way.
+The handler runs on a goroutine of the subscription after the first replay, which runs on the caller's. No lock or
+transaction is held while it runs, so it may write to the bucket it watches.
+*/
+func SubscribeFrom[T any](ctx *Context, bucketID BucketID, after int64, handler func(change Change[T])) (unsubscribe func(), err error) {
+	changeFeedMutex.RLock()
+	logged := changeLogBuckets[bucketID]
+	changeFeedMutex.RUnlock()
+	if !logged {
+		return nil, fmt.Errorf("Change log is not enabled for %v, see EnableChangeLog", bucketID)
+	}
+
+	wake := make(chan struct{}, 1)
+	done := make(chan struct{})
+	stop := subscribe(bucketID, func(event changeEvent) {
+		select {
+		case wake <- struct{}{}:
+		default: // Already woken up, the next read picks this change up too
+		}
+	})
+
+	last, err := deliverLoggedChanges(ctx, bucketID, after, handler)
+	if err != nil {
+		stop()
+		return nil, err
+	}
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-wake:
+			}
+
+			var err error
+			last, err = deliverLoggedChanges(ctx, bucketID, last, handler)
+			if err != nil {
+				log.Printf("Change subscriber of %v FAILED to read the change log: %v", bucketID, err)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			stop()
+			close(done)
+		})
+	}, nil
+}
+
+// deliverLoggedChanges reads changes in batches and calls the handler between the reads, returns the last delivered sequence
+func deliverLoggedChanges[T any](ctx *Context, bucketID BucketID, after int64, handler func(change Change[T])) (last int64, err error) {
+	last = after
+	for {
+		var batch []Change[T]
+		err = ReadChanges(ctx, bucketID, last, func(change Change[T]) bool {
+			batch = append(batch, change)
+			return len(batch) < CHANGE_DELIVERY_BATCH
+		})
+		if err != nil {
+			return last, err
+		}
+
+		for _, change := range batch {
+			last = change.Sequence
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						log.Printf("Change subscriber of %v panicked: %v", bucketID, r)
+					}
+				}()
+				handler(change)
+			}()
+		}
+		if len(batch) < CHANGE_DELIVERY_BATCH {
+			return last, nil
+		}
+	}
+}
+
+// TrimChangeLog removes logged changes up to and including the sequence
+func TrimChangeLog(ctx *Context, upTo int64) (removed int, err error) {
+	err = ctx.Database.Update(func(tx Tx) error {
+		changes, err := GetBucket(tx, BUCKET_CHANGES)
+		if err != nil {
+			return err
+		}
+
+		var keys [][]byte
+		cursor := changes.Cursor()
+		for key, _ := cursor.First(); key != nil && int64(binary.BigEndian.Uint64(key)) <= upTo; key, _ = cursor.Next() {
+			keys = append(keys, append([]byte(nil), key...))
+		}
+
+		for _, key := range keys {
+			err := changes.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+		removed = len(keys)
+		return nil
+	})
+	return removed, err
+}
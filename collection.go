@@ -0,0 +1,356 @@
+package easyframework
+
+import (
+	"fmt"
+	"time"
+)
+
+type RecordNotFoundError struct {
+	Bucket BucketID
+	ID     ID128
+}
+
+func (v RecordNotFoundError) Error() string {
+	return fmt.Sprintf("Record %v not found in %v", v.ID, v.Bucket)
+}
+
+type RecordUnpackError struct {
+	Bucket BucketID
+	ID     ID128
+	Err    error
+}
+
+func (v RecordUnpackError) Error() string {
+	return fmt.Sprintf("Record %v in %v can't be unpacked: %v", v.ID, v.Bucket, v.Err)
+}
+
+func (v RecordUnpackError) Unwrap() error {
+	return v.Err
+}
+
+// Collection is a bucket of T. Every method runs in its own transaction, use In() to work inside an existing one.
+type Collection[T any] struct {
+	Context *Context
+	Bucket  BucketID
+}
+
+// CollectionTx is a Collection bound to a transaction, it is only valid until the transaction ends
+type CollectionTx[T any] struct {
+	BucketID BucketID
+	Tx       Tx
+	Bucket   Bucket
+}
+
+// NewCollection creates the bucket and the indexes of T if they don't exist yet, records of collections have versions
+func NewCollection[T any](ctx *Context, bucketID BucketID) (Collection[T], error) {
+	collection := Collection[T]{
+		Context: ctx,
+		Bucket:  bucketID,
+	}
+
+	err := NewBucket(ctx, bucketID)
+	if err != nil {
+		return collection, err
+	}
+	registerExpiringBucket[T](bucketID)
+
+	err = EnableVersions(ctx, bucketID)
+	if err != nil {
+		return collection, err
+	}
+
+	return collection, EnsureIndexes[T](ctx, bucketID)
+}
+
+func (collection Collection[T]) In(tx Tx) (CollectionTx[T], error) {
+	bucket, err := GetBucket(tx, collection.Bucket)
+	if err != nil {
+		return CollectionTx[T]{}, err
+	}
+
+	return CollectionTx[T]{
+		BucketID: collection.Bucket,
+		Tx:       tx,
+		Bucket:   bucket,
+	}, nil
+}
+
+func (collection Collection[T]) view(procedure func(collectionTx CollectionTx[T]) error) error {
+	return collection.Context.Database.View(func(tx Tx) error {
+		collectionTx, err := collection.In(tx)
+		if err != nil {
+			return err
+		}
+		return procedure(collectionTx)
+	})
+}
+
+func (collection Collection[T]) update(procedure func(collectionTx CollectionTx[T]) error) error {
+	return collection.Context.Database.Update(func(tx Tx) error {
+		collectionTx, err := collection.In(tx)
+		if err != nil {
+			return err
+		}
+		return procedure(collectionTx)
+	})
+}
+
+func (collection Collection[T]) Get(ID ID128) (value T, err error) {
+	err = collection.view(func(collectionTx CollectionTx[T]) error {
+		value, err = collectionTx.Get(ID)
+		return err
+	})
+	return value, err
+}
+
+func (collection Collection[T]) Put(ID ID128, value *T) error {
+	return collection.update(func(collectionTx CollectionTx[T]) error {
+		return collectionTx.Put(ID, value)
+	})
+}
+
+func (collection Collection[T]) PutWithTTL(ID ID128, value *T, ttl time.Duration) error {
+	return collection.update(func(collectionTx CollectionTx[T]) error {
+		return collectionTx.PutWithTTL(ID, value, ttl)
+	})
+}
+
+// GetID, PutID and DeleteID take the typed ID, so an ID of another entity doesn't compile
+func (collection Collection[T]) GetID(ID ID[T]) (T, error) {
+	return collection.Get(ID.Raw())
+}
+
+func (collection Collection[T]) PutID(ID ID[T], value *T) error {
+	return collection.Put(ID.Raw(), value)
+}
+
+func (collection Collection[T]) DeleteID(ID ID[T]) error {
+	return collection.Delete(ID.Raw())
+}
+
+func (collection Collection[T]) GetWithVersion(ID ID128) (value T, version int64, err error) {
+	err = collection.view(func(collectionTx CollectionTx[T]) error {
+		value, version, err = collectionTx.GetWithVersion(ID)
+		return err
+	})
+	return value, version, err
+}
+
+func (collection Collection[T]) CompareAndSwap(ID ID128, version int64, value *T) (newVersion int64, err error) {
+	err = collection.update(func(collectionTx CollectionTx[T]) error {
+		newVersion, err = collectionTx.CompareAndSwap(ID, version, value)
+		return err
+	})
+	return newVersion, err
+}
+
+// Update changes the record with the procedure, retrying on conflicts, see UpdateByID
+func (collection Collection[T]) Update(ID ID128, procedure func(value *T) error) error {
+	return UpdateByID(collection.Context, collection.Bucket, ID, procedure)
+}
+
+func (collection Collection[T]) Delete(ID ID128) error {
+	return collection.update(func(collectionTx CollectionTx[T]) error {
+		return collectionTx.Delete(ID)
+	})
+}
+
+func (collection Collection[T]) Exists(ID ID128) (exists bool, err error) {
+	err = collection.view(func(collectionTx CollectionTx[T]) error {
+		exists, err = collectionTx.Exists(ID)
+		return err
+	})
+	return exists, err
+}
+
+func (collection Collection[T]) Count() (count int, err error) {
+	err = collection.view(func(collectionTx CollectionTx[T]) error {
+		count, err = collectionTx.Count()
+		return err
+	})
+	return count, err
+}
+
+// Scan calls the procedure for every record until it returns false
+func (collection Collection[T]) Scan(procedure func(ID ID128, value *T) bool) error {
+	return collection.view(func(collectionTx CollectionTx[T]) error {
+		return collectionTx.Scan(procedure)
+	})
+}
+
+// Filter collects every record the condition returns true for
+func (collection Collection[T]) Filter(condition func(ID ID128, value *T) bool) (result []T, err error) {
+	err = collection.view(func(collectionTx CollectionTx[T]) error {
+		result, err = collectionTx.Filter(condition)
+		return err
+	})
+	return result, err
+}
+
+func (collection Collection[T]) Page(request PageRequest) (response PageResponse[T], err error) {
+	err = collection.view(func(collectionTx CollectionTx[T]) error {
+		response, err = collectionTx.Page(request)
+		return err
+	})
+	return response, err
+}
+
+// FindBy returns the record with the value in the index, RecordNotFoundError if there is none
+func (collection Collection[T]) FindBy(indexName string, value interface{}) (ID ID128, result T, err error) {
+	err = collection.view(func(collectionTx CollectionTx[T]) error {
+		ID, result, err = collectionTx.FindBy(indexName, value)
+		return err
+	})
+	return ID, result, err
+}
+
+func (collection Collection[T]) FindAllBy(indexName string, value interface{}) (result []T, err error) {
+	err = collection.view(func(collectionTx CollectionTx[T]) error {
+		result, err = collectionTx.FindAllBy(indexName, value)
+		return err
+	})
+	return result, err
+}
+
+// ScanRange goes over records with from <= value < to in the order of the index, nil means no bound
+func (collection Collection[T]) ScanRange(indexName string, from, to interface{}, procedure func(ID ID128, value *T) bool) error {
+	return collection.view(func(collectionTx CollectionTx[T]) error {
+		return collectionTx.ScanRange(indexName, from, to, procedure)
+	})
+}
+
+func (collectionTx CollectionTx[T]) Get(ID ID128) (T, error) {
+	var value T
+	data := collectionTx.Bucket.Get(ID[:])
+	if data == nil || IsExpired(collectionTx.Bucket, ID) {
+		return value, RecordNotFoundError{Bucket: collectionTx.BucketID, ID: ID}
+	}
+
+	err := Unpack(data, &value)
+	if err != nil {
+		return value, RecordUnpackError{Bucket: collectionTx.BucketID, ID: ID, Err: err}
+	}
+
+	return value, nil
+}
+
+func (collectionTx CollectionTx[T]) Put(ID ID128, value *T) error {
+	return Insert(collectionTx.Bucket, ID, value)
+}
+
+// PutWithTTL stores the record until now + ttl, after that it is treated as missing and eventually swept
+func (collectionTx CollectionTx[T]) PutWithTTL(ID ID128, value *T, ttl time.Duration) error {
+	return InsertWithExpiry(collectionTx.Tx, collectionTx.BucketID, ID, value, time.Now().Add(ttl))
+}
+
+func (collectionTx CollectionTx[T]) GetID(ID ID[T]) (T, error) {
+	return collectionTx.Get(ID.Raw())
+}
+
+func (collectionTx CollectionTx[T]) PutID(ID ID[T], value *T) error {
+	return collectionTx.Put(ID.Raw(), value)
+}
+
+func (collectionTx CollectionTx[T]) DeleteID(ID ID[T]) error {
+	return collectionTx.Delete(ID.Raw())
+}
+
+func (collectionTx CollectionTx[T]) GetWithVersion(ID ID128) (T, int64, error) {
+	value, version, found, err := GetWithVersion[T](collectionTx.Bucket, ID)
+	if err != nil {
+		return value, 0, RecordUnpackError{Bucket: collectionTx.BucketID, ID: ID, Err: err}
+	}
+	if !found {
+		return value, 0, RecordNotFoundError{Bucket: collectionTx.BucketID, ID: ID}
+	}
+	return value, version, nil
+}
+
+// CompareAndSwap fails with VersionConflictError if the record is not at the version anymore, zero version means it must not exist
+func (collectionTx CollectionTx[T]) CompareAndSwap(ID ID128, version int64, value *T) (int64, error) {
+	return CompareAndSwap(collectionTx.Bucket, ID, version, value)
+}
+
+// Delete fails with RecordNotFoundError if there is no such record
+func (collectionTx CollectionTx[T]) Delete(ID ID128) error {
+	if exists, _ := collectionTx.Exists(ID); !exists {
+		return RecordNotFoundError{Bucket: collectionTx.BucketID, ID: ID}
+	}
+
+	return Delete[T](collectionTx.Bucket, ID)
+}
+
+func (collectionTx CollectionTx[T]) Exists(ID ID128) (bool, error) {
+	return collectionTx.Bucket.Get(ID[:]) != nil && !IsExpired(collectionTx.Bucket, ID), nil
+}
+
+func (collectionTx CollectionTx[T]) Count() (int, error) {
+	count := 0
+	expiry := NewExpiryChecker(collectionTx.Bucket)
+	cursor := collectionTx.Bucket.Cursor()
+	for key, data := cursor.First(); key != nil; key, data = cursor.Next() {
+		if data != nil && !expiry.IsExpired(key) { // Nested buckets (indexes) are not records
+			count += 1
+		}
+	}
+	return count, nil
+}
+
+func (collectionTx CollectionTx[T]) Scan(procedure func(ID ID128, value *T) bool) error {
+	expiry := NewExpiryChecker(collectionTx.Bucket)
+	cursor := collectionTx.Bucket.Cursor()
+	for key, data := cursor.First(); key != nil; key, data = cursor.Next() {
+		if data == nil || expiry.IsExpired(key) {
+			continue
+		}
+
+		var value T
+		err := Unpack(data, &value)
+		if err != nil {
+			return RecordUnpackError{Bucket: collectionTx.BucketID, ID: ID128(key), Err: err}
+		}
+
+		if !procedure(ID128(key), &value) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (collectionTx CollectionTx[T]) Filter(condition func(ID ID128, value *T) bool) ([]T, error) {
+	var result []T
+	err := collectionTx.Scan(func(ID ID128, value *T) bool {
+		if condition(ID, value) {
+			result = append(result, *value)
+		}
+		return true
+	})
+
+	return result, err
+}
+
+func (collectionTx CollectionTx[T]) Page(request PageRequest) (PageResponse[T], error) {
+	response, err := Paginate[T](collectionTx.Bucket, request)
+	if unpackError, ok := err.(RecordUnpackError); ok {
+		unpackError.Bucket = collectionTx.BucketID
+		err = unpackError
+	}
+	return response, err
+}
+
+func (collectionTx CollectionTx[T]) FindBy(indexName string, value interface{}) (ID128, T, error) {
+	ID, result, found, err := FindByIndex[T](collectionTx.Bucket, indexName, value)
+	if err == nil && !found {
+		err = RecordNotFoundError{Bucket: collectionTx.BucketID}
+	}
+	return ID, result, err
+}
+
+func (collectionTx CollectionTx[T]) FindAllBy(indexName string, value interface{}) ([]T, error) {
+	return FindAllByIndex[T](collectionTx.Bucket, indexName, value)
+}
+
+func (collectionTx CollectionTx[T]) ScanRange(indexName string, from, to interface{}, procedure func(ID ID128, value *T) bool) error {
+	return ScanIndexRange(collectionTx.Bucket, indexName, from, to, procedure)
+}
@@ -0,0 +1,62 @@
+package easyframework
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Command is a maintenance task that runs instead of the server, e.g. "myapp restore snapshot.db"
+type Command struct {
+	Name        string
+	Usage       string // Arguments, shown in help
+	Description string
+	Run         func(params InitializeParams, args []string) error // The database is not open, commands open it themselves if needed
+}
+
+var commands = make(map[string]Command)
+
+func RegisterCommand(command Command) {
+	commands[command.Name] = command
+}
+
+/*
+RunCommand is meant to be called at the start of main, before Initialize:
+
+	if handled, err := ef.RunCommand(params, os.Args[1:]); handled {
+		...
+	}
+
+handled is false if args don't name a command, then the server should start as usual.
+*/
+func RunCommand(params InitializeParams, args []string) (handled bool, err error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	if args[0] == "help" {
+		PrintCommands()
+		return true, nil
+	}
+
+	command, ok := commands[args[0]]
+	if !ok {
+		return false, nil
+	}
+
+	return true, command.Run(params, args[1:])
+}
+
+func PrintCommands() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	for _, name := range names {
+		command := commands[name]
+		fmt.Fprintf(os.Stderr, "  %v %v\n      %v\n", command.Name, command.Usage, command.Description)
+	}
+}
@@ -0,0 +1,74 @@
+package easyframework
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+type ConcurrencyLimiter struct {
+	Slots        chan struct{} // Buffered with MaxConcurrent capacity, a call holds one element while it runs
+	Queued       *int64
+	QueueSize    int
+	QueueTimeout time.Duration // Zero means wait until the client goes away
+}
+
+func NewConcurrencyLimiter(maxConcurrent, queueSize int, queueTimeout time.Duration) *ConcurrencyLimiter {
+	var queued int64
+	return &ConcurrencyLimiter{
+		Slots:        make(chan struct{}, maxConcurrent),
+		Queued:       &queued,
+		QueueSize:    queueSize,
+		QueueTimeout: queueTimeout,
+	}
+}
+
+// Acquire takes a slot, waiting in the queue if all slots are busy. Returns false if the queue is full or the wait timed out.
+func (limiter *ConcurrencyLimiter) Acquire(ctx context.Context) bool {
+	select {
+	case limiter.Slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if atomic.AddInt64(limiter.Queued, 1) > int64(limiter.QueueSize) {
+		atomic.AddInt64(limiter.Queued, -1)
+		return false
+	}
+	defer atomic.AddInt64(limiter.Queued, -1)
+
+	var timeout <-chan time.Time
+	if limiter.QueueTimeout > 0 {
+		timer := time.NewTimer(limiter.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case limiter.Slots <- struct{}{}:
+		return true
+	case <-timeout:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (limiter *ConcurrencyLimiter) Release() {
+	<-limiter.Slots
+}
+
+// EnterInFlight counts the request towards the global in-flight limit, returns false if the server should shed it.
+// LeaveInFlight must be called in both cases.
+func EnterInFlight(ctx *Context) bool {
+	inFlight := atomic.AddInt64(&ctx.InFlightRequests, 1)
+	if ctx.MaxInFlightRequests > 0 && inFlight > int64(ctx.MaxInFlightRequests) {
+		return false
+	}
+
+	return true
+}
+
+func LeaveInFlight(ctx *Context) {
+	atomic.AddInt64(&ctx.InFlightRequests, -1)
+}
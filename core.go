@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/boltdb/bolt"
 	"github.com/gorilla/mux"
 	"io"
 	"log"
@@ -26,13 +25,35 @@ type Context struct {
 	StaticData     map[string]string
 	Port           int
 	DatabasePath   string
-	Database       *bolt.DB
+	Database       Storage
 	Authorization  func(*RequestContext, http.ResponseWriter, *http.Request) bool
 	StdoutLogging  bool
 	FileLogging    bool
 	LogFile        *os.File
 	GorillaRouter  *mux.Router
 	RateLimiter    RateLimiter
+
+	InFlightRequests    int64 // Accessed atomically
+	MaxInFlightRequests int   // Requests above this limit are shed with ERROR_OVERLOADED, zero means no limit
+
+	Sessions *SessionParams // nil if built-in sessions are disabled
+
+	PermissionResolver PermissionResolver
+	RolePermissions    map[string][]string // Role -> permissions it grants, "*" grants everything
+
+	APIKeys *APIKeyParams // nil if API keys are disabled
+	JWT     *JWTParams    // nil if tokens are disabled
+	CSRF    *CSRFParams   // nil if CSRF protection is disabled
+
+	LoginGuard *LoginGuardParams // nil if login attempts are not tracked
+
+	ExpirySweepPeriod time.Duration // How often expired records are removed
+
+	Backups *BackupParams // nil if snapshots are disabled
+
+	Integrity *IntegrityParams // nil if integrity checks only run on demand
+
+	Encryption *EncryptionParams // nil if nothing is encrypted
 }
 
 func (ctx Context) Write(bytes []byte) (int, error) {
@@ -59,6 +80,10 @@ type Procedure struct {
 	Documentation            string
 	CustomResponse           bool
 	UserData                 interface{}
+	ConcurrencyLimiter       *ConcurrencyLimiter // nil if the procedure has no MaxConcurrent
+	Permissions              []string            // Caller needs all of them
+	CSRFExempt               bool
+	Transaction              TransactionMode
 }
 
 type InitializeParams struct {
@@ -66,10 +91,27 @@ type InitializeParams struct {
 	StdoutLogging        bool
 	FileLogging          bool
 	DatabasePath         string
+	Storage              Storage // Used instead of DatabasePath, e.g. NewMemoryStorage() for tests
 	Rest                 bool
 	RestMethods          []string
 	Authorization        func(*RequestContext, http.ResponseWriter, *http.Request) bool
 	MaxRequestsPerMinute int
+	RateLimiterStorage   RateLimiterStorage // Optional, share one storage between contexts to share the limits
+	PersistRateLimits    bool               // Keep rate limiter counters in the database (requires DatabasePath)
+	MaxInFlightRequests  int
+	Sessions             *SessionParams // Enables built-in sessions, they become the default Authorization
+	PermissionResolver   PermissionResolver
+	RolePermissions      map[string][]string
+	APIKeys              *APIKeyParams // Enables API key authorization, tried before the other Authorization
+	JWT                  *JWTParams    // Enables stateless HS256 tokens, tried before sessions
+	CSRF                 *CSRFParams   // Enables CSRF checks for cookie authenticated procedures
+	LoginGuard           *LoginGuardParams
+	Migrations           []Migration       // Applied in order before anything else touches the database
+	MigrationsDryRun     bool              // Report what migrations would do and stop, nothing is written
+	ExpirySweepPeriod    time.Duration     // Default is 1 minute
+	Backups              *BackupParams     // Enables scheduled snapshots and Backup.Download
+	Integrity            *IntegrityParams  // Enables scheduled integrity checks and Integrity.Check
+	Encryption           *EncryptionParams // Required by fields tagged encrypt, and encrypts whole buckets
 }
 
 func Initialize(ctx *Context, params InitializeParams) error {
@@ -79,30 +121,55 @@ func Initialize(ctx *Context, params InitializeParams) error {
 	ctx.StdoutLogging = params.StdoutLogging
 	ctx.Authorization = params.Authorization
 	ctx.Port = params.Port
+	ctx.MaxInFlightRequests = params.MaxInFlightRequests
+	ctx.PermissionResolver = params.PermissionResolver
+	ctx.RolePermissions = params.RolePermissions
 	ctx.StaticData = make(map[string]string)
 
-	CreateDirectoryIfDoesntExist("logs")
+	if ctx.FileLogging {
+		CreateDirectoryIfDoesntExist("logs")
+	}
+
+	if params.Storage != nil { // Setup database
+		ctx.Database = params.Storage
+	} else if params.DatabasePath != "" {
+		database, err := OpenBoltStorage(params.DatabasePath, 0777, nil)
+		if err != nil {
+			return err
+		}
+
+		ctx.Database = database
+	}
+
+	if params.Encryption != nil { // Before anything reads or writes the database
+		err := InitializeEncryption(ctx, *params.Encryption)
+		if err != nil {
+			return err
+		}
+	}
 
 	ctx.RateLimiter.MaxRequestsPerMinute = params.MaxRequestsPerMinute
 	if ctx.RateLimiter.MaxRequestsPerMinute == 0 {
 		ctx.RateLimiter.MaxRequestsPerMinute = 120
 	}
-	{
-		_map := make(map[string]int, 0)
-		ctx.RateLimiter.UserRequestsCount = &_map
-	}
-
-	go RateLimiterRoutine(ctx)
+	if params.RateLimiterStorage != nil {
+		ctx.RateLimiter.Storage = params.RateLimiterStorage
+	} else if params.PersistRateLimits {
+		if ctx.Database == nil {
+			return errors.New("PersistRateLimits requires DatabasePath")
+		}
 
-	if params.DatabasePath != "" { // Setup database
-		database, err := bolt.Open(params.DatabasePath, 0777, nil)
+		storage, err := NewBoltRateLimiterStorage(ctx)
 		if err != nil {
 			return err
 		}
-
-		ctx.Database = database
+		ctx.RateLimiter.Storage = storage
+	} else {
+		ctx.RateLimiter.Storage = NewMemoryRateLimiterStorage()
 	}
 
+	go RateLimiterRoutine(ctx)
+
 	// Setup logging
 	{
 		log.SetFlags(0)
@@ -122,8 +189,98 @@ func Initialize(ctx *Context, params InitializeParams) error {
 		log.SetOutput(ctx)
 	}
 
+	if len(params.Migrations) > 0 {
+		if ctx.Database == nil {
+			return errors.New("Migrations require DatabasePath")
+		}
+
+		err := RunMigrations(ctx, params.Migrations, params.MigrationsDryRun)
+		if err != nil {
+			return err
+		}
+		if params.MigrationsDryRun {
+			return errors.New("Migrations dry run is done, start without MigrationsDryRun to apply them")
+		}
+	}
+
+	if ctx.Database != nil {
+		ctx.ExpirySweepPeriod = params.ExpirySweepPeriod
+		if ctx.ExpirySweepPeriod == 0 {
+			ctx.ExpirySweepPeriod = time.Minute
+		}
+
+		go ExpirySweeperRoutine(ctx)
+	}
+
+	if params.Sessions != nil {
+		if ctx.Database == nil {
+			return errors.New("Sessions require DatabasePath")
+		}
+
+		err := InitializeSessions(ctx, *params.Sessions)
+		if err != nil {
+			return err
+		}
+
+		if ctx.Authorization == nil {
+			ctx.Authorization = SessionAuthorization
+		}
+	}
+
+	if params.JWT != nil {
+		err := InitializeJWT(ctx, *params.JWT)
+		if err != nil {
+			return err
+		}
+	}
+
 	ctx.GorillaRouter = mux.NewRouter()
 
+	if params.CSRF != nil {
+		InitializeCSRF(ctx, *params.CSRF)
+	}
+
+	if params.LoginGuard != nil {
+		if ctx.Database == nil {
+			return errors.New("LoginGuard requires DatabasePath")
+		}
+
+		err := InitializeLoginGuard(ctx, *params.LoginGuard)
+		if err != nil {
+			return err
+		}
+	}
+
+	if params.APIKeys != nil {
+		if ctx.Database == nil {
+			return errors.New("APIKeys require DatabasePath")
+		}
+
+		err := InitializeAPIKeys(ctx, *params.APIKeys)
+		if err != nil {
+			return err
+		}
+	}
+
+	if params.Backups != nil {
+		if ctx.Database == nil {
+			return errors.New("Backups require DatabasePath")
+		}
+
+		err := InitializeBackups(ctx, *params.Backups)
+		if err != nil {
+			return err
+		}
+	}
+
+	if params.Integrity != nil {
+		if ctx.Database == nil {
+			return errors.New("Integrity checks require DatabasePath")
+		}
+
+		InitializeIntegrity(ctx, *params.Integrity)
+	}
+
 	return nil
 }
 
@@ -180,18 +337,14 @@ func (ef *Context) ServeHTTP(writer http.ResponseWriter, request *http.Request)
 	requestID := NewID128().String()
 	data, _ := io.ReadAll(request.Body)
 	ip, _, _ := net.SplitHostPort(request.RemoteAddr)
-	log.Printf("[%v][In] %v (%v): %v", ip, request.RequestURI, requestID, string(data))
-
-	requestCount, shouldBeRateLimited := ShouldRequestBeRateLimited(ef, writer, request)
-	if shouldBeRateLimited {
-		log.Printf("[Rate limited (%v)]", requestCount)
-		return
-	}
 
 	var procedure Procedure
 	var procedureFound bool
+	staticFilepath := ""
+	isStatic := false
 
 	requestContext := RequestContext{
+		Context:        ef,
 		Procedure:      &procedure,
 		ResponseWriter: writer,
 		Request:        request,
@@ -214,17 +367,43 @@ func (ef *Context) ServeHTTP(writer http.ResponseWriter, request *http.Request)
 			}
 			request.URL.Path = savedRequestURL
 		} else { // Static content
+			isStatic = true
 			staticName := strings.TrimLeft(request.RequestURI, "/")
-			filepath, ok := ef.StaticData[staticName]
-			if !ok {
-				RJson(writer, 400, Problem{
-					ErrorID: ERROR_STATIC_CONTENT_NOT_FOUND,
-				})
-				return
-			}
-			http.ServeFile(writer, request, filepath)
+			staticFilepath = ef.StaticData[staticName]
+		}
+	}
+
+	loggedData := string(data)
+	if procedureFound && procedure.InputType != nil {
+		loggedData = RedactPasswordsForLog(procedure.InputType, data)
+	}
+	log.Printf("[%v][In] %v (%v): %v", ip, request.RequestURI, requestID, loggedData)
+
+	requestCount, shouldBeRateLimited := ShouldRequestBeRateLimited(ef, writer, request)
+	if shouldBeRateLimited {
+		log.Printf("[Rate limited (%v)]", requestCount)
+		return
+	}
+
+	defer LeaveInFlight(ef)
+	if !EnterInFlight(ef) {
+		RJson(writer, 503, Problem{
+			ErrorID: ERROR_OVERLOADED,
+			Message: "Server is overloaded",
+		})
+		log.Println("[Overloaded]")
+		return
+	}
+
+	if isStatic {
+		if staticFilepath == "" {
+			RJson(writer, 400, Problem{
+				ErrorID: ERROR_STATIC_CONTENT_NOT_FOUND,
+			})
 			return
 		}
+		http.ServeFile(writer, request, staticFilepath)
+		return
 	}
 
 	if !procedureFound {
@@ -247,6 +426,24 @@ func (ef *Context) ServeHTTP(writer http.ResponseWriter, request *http.Request)
 		}
 	}
 
+	if reason, ok := CheckCSRF(ef, &requestContext); !ok {
+		RJson(writer, 403, Problem{
+			ErrorID: ERROR_CSRF_CHECK_FAILED,
+			Message: reason,
+		})
+		log.Printf("[CSRF check failed: %v]", reason)
+		return
+	}
+
+	if missingPermission, allowed := CheckProcedurePermissions(ef, &requestContext); !allowed {
+		RJson(writer, 403, Problem{
+			ErrorID: ERROR_FORBIDDEN,
+			Message: fmt.Sprintf("Missing permission: %v", missingPermission),
+		})
+		log.Printf("[Forbidden, missing %v]", missingPermission)
+		return
+	}
+
 	var args []reflect.Value
 	if procedure.InputType != nil { // 2 input args (context, request) scenario
 		requestInput := reflect.New(procedure.InputType)
@@ -283,6 +480,31 @@ func (ef *Context) ServeHTTP(writer http.ResponseWriter, request *http.Request)
 		}
 	}
 
+	if procedure.ConcurrencyLimiter != nil {
+		if !procedure.ConcurrencyLimiter.Acquire(request.Context()) {
+			RJson(writer, 503, Problem{
+				ErrorID: ERROR_OVERLOADED,
+				Message: "Too many concurrent calls",
+			})
+			log.Println("[Procedure overloaded]")
+			return
+		}
+		defer procedure.ConcurrencyLimiter.Release()
+	}
+
+	tx, err := beginProcedureTransaction(ef, &procedure)
+	if err != nil {
+		log.Printf("Failed to begin a transaction: %v", err)
+		RJson(writer, 500, Problem{
+			ErrorID: ERROR_INTERNAL,
+		})
+		return
+	}
+	if tx != nil {
+		defer endProcedureTransaction(tx)
+		requestContext.Tx = tx
+	}
+
 	returnValues := procedure.Procedure.Call(args)
 	var response reflect.Value
 	var problem reflect.Value
@@ -319,6 +541,17 @@ func (ef *Context) ServeHTTP(writer http.ResponseWriter, request *http.Request)
 	}
 	LookupErrorCode(problem)
 
+	if errorCode == ERROR_NONE || errorCode == "" {
+		err := commitProcedureTransaction(tx)
+		if err != nil {
+			log.Printf("Failed to commit the transaction of %v: %v", procedure.Identifier, err)
+			RJson(writer, 500, Problem{
+				ErrorID: ERROR_INTERNAL,
+			})
+			return
+		}
+	}
+
 	responseText := ""
 	if errorCode == ERROR_NONE || errorCode == "" {
 		if !procedure.CustomResponse {
@@ -328,6 +561,7 @@ func (ef *Context) ServeHTTP(writer http.ResponseWriter, request *http.Request)
 					log.Printf("Error while trying to marshal json to send it as response: %v", err)
 					return
 				}
+				data = RemovePasswords(procedure.OutputType, response, data)
 				writer.Header().Set("Content-Type", "application/json")
 				writer.WriteHeader(200)
 				fmt.Fprintf(writer, string(data))
@@ -356,12 +590,21 @@ func (ef *Context) ServeHTTP(writer http.ResponseWriter, request *http.Request)
 }
 
 type RequestContext struct {
+	Context        *Context
 	Procedure      *Procedure
 	ResponseWriter http.ResponseWriter
 	Request        *http.Request
 	RequestID      string
 	SessionToken   string
+	Session        *Session         // Filled by SessionAuthorization
+	UserID         ID[User]         // Filled by SessionAuthorization
+	Principal      *Principal       // Filled for procedures that require permissions
+	APIKey         *APIKey          // Filled by APIKeyAuthorization
+	Credential     CredentialSource // Where the credential that authenticated the request came from, filled by Authorization
+	JWTClaims      *JWTClaims       // Filled by JWTAuthorization
+	Claims         interface{}      // Custom JWT claims, see GetClaims
 	Vars           map[string]string
+	Tx             Tx // Open during the call for procedures with a Transaction
 }
 
 type NewRPCParams struct {
@@ -374,6 +617,12 @@ type NewRPCParams struct {
 	Rest                     bool
 	RestMethods              string
 	UserData                 interface{}
+	MaxConcurrent            int             // Zero means no limit
+	MaxQueued                int             // Calls waiting for a free slot, above that they are rejected with ERROR_OVERLOADED
+	QueueTimeout             time.Duration   // How long a call may wait in the queue, zero means until the client gives up
+	Permissions              []string        // Checked with Context.PermissionResolver, a missing one is rejected with ERROR_FORBIDDEN
+	CSRFExempt               bool            // Skip CSRF checks even if they are enabled
+	Transaction              TransactionMode // Open a transaction for the call, see RequestContext.Tx
 }
 
 func NewRPC(efContext *Context, params NewRPCParams) {
@@ -391,6 +640,21 @@ func NewRPC(efContext *Context, params NewRPCParams) {
 		panic("Cannot continue!")
 	}
 
+	if len(params.Permissions) > 0 && params.AuthorizationNotRequired {
+		log.Printf("NewRPC(): %v requires permissions, so it can't have AuthorizationNotRequired", params.Name)
+		panic("Cannot continue!")
+	}
+
+	if params.Transaction != TRANSACTION_NONE && efContext.Database == nil {
+		log.Printf("NewRPC(): %v needs a transaction, but there is no database", params.Name)
+		panic("Cannot continue!")
+	}
+
+	if params.Transaction == TRANSACTION_WRITE && params.CustomResponse {
+		log.Printf("NewRPC(): %v writes a custom response, it would be sent before the commit that may still fail", params.Name)
+		panic("Cannot continue!")
+	}
+
 	if handlerTypeof.NumIn() > 2 {
 		log.Println("NewRPC(): input signature is not correct, expected (*RequestContext, (any type) <- optional) as input signature", params.Name)
 		panic("Cannot continue!")
@@ -467,6 +731,12 @@ func NewRPC(efContext *Context, params NewRPCParams) {
 		Category:                 params.Category,
 		CustomResponse:           params.CustomResponse,
 		UserData:                 params.UserData,
+		Permissions:              params.Permissions,
+		CSRFExempt:               params.CSRFExempt,
+		Transaction:              params.Transaction,
+	}
+	if params.MaxConcurrent > 0 {
+		procedure.ConcurrencyLimiter = NewConcurrencyLimiter(params.MaxConcurrent, params.MaxQueued, params.QueueTimeout)
 	}
 	{ // Generate procedure documentation
 		var sb strings.Builder
@@ -483,6 +753,18 @@ func NewRPC(efContext *Context, params NewRPCParams) {
 			sb.WriteString(fmt.Sprintf("<b>Description</b>: %v\n", procedure.Description))
 		}
 
+		if len(procedure.Permissions) > 0 {
+			sb.WriteString(fmt.Sprintf("<b>Permissions</b>: %v\n", strings.Join(procedure.Permissions, ", ")))
+		}
+
+		if procedure.Transaction != TRANSACTION_NONE {
+			sb.WriteString(fmt.Sprintf("<b>Transaction</b>: %v\n", procedure.Transaction))
+		}
+
+		if isPaginated(procedure.InputType, procedure.OutputType) {
+			sb.WriteString("<b>Paginated</b>: pass NextCursor of the response as Cursor to get the next page\n")
+		}
+
 		sb.WriteString("<h4>Request:</h2>\n")
 		sb.WriteString("<code>")
 
@@ -542,6 +824,11 @@ const (
 	ERROR_AUTHENTICATION_FAILED            = "authentication_failed"
 	ERROR_STATIC_CONTENT_NOT_FOUND         = "static_content_not_found"
 	ERROR_REST_PROCEDURE_NOT_FOUND         = "rest_procedure_not_found"
+	ERROR_OVERLOADED                       = "overloaded"
+	ERROR_FORBIDDEN                        = "forbidden"
+	ERROR_CSRF_CHECK_FAILED                = "csrf_check_failed"
+	ERROR_ACCOUNT_LOCKED                   = "account_locked"
+	ERROR_API_KEY_NOT_FOUND                = "api_key_not_found"
 )
 
 type Problem struct {
@@ -629,7 +916,8 @@ func (id ID128) MarshalJSON() ([]byte, error) {
 	return json.Marshal(string(id.String()))
 }
 
-func (id ID128) UnmarshalJSON(src []byte) error {
+// UnmarshalJSON accepts every format ParseID128 does
+func (id *ID128) UnmarshalJSON(src []byte) error {
 	if bytes.Equal(src, []byte("null")) {
 		return nil // no error
 	}
@@ -639,7 +927,7 @@ func (id ID128) UnmarshalJSON(src []byte) error {
 	if err != nil {
 		return err
 	}
-	err = id.FromString(strValue)
+	*id, err = ParseID128(strValue)
 	return err
 }
 
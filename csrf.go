@@ -0,0 +1,159 @@
+package easyframework
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type CSRFParams struct {
+	DoubleSubmit    bool     // The header must repeat the value of the token cookie
+	CheckOrigin     bool     // Origin (or Referer) must be the host itself or one of AllowedOrigins
+	AllowedOrigins  []string // Like "https://app.example.com"
+	CookieName      string   // Default is "csrf_token"
+	HeaderName      string   // Default is "X-CSRF-Token"
+	InsecureCookies bool     // Drop the Secure attribute, only for local development over plain http
+}
+
+const CSRF_TOKEN_PROCEDURE = "CsrfToken"
+
+func InitializeCSRF(ctx *Context, params CSRFParams) {
+	if !params.DoubleSubmit && !params.CheckOrigin {
+		params.DoubleSubmit = true
+		params.CheckOrigin = true
+	}
+	if params.CookieName == "" {
+		params.CookieName = "csrf_token"
+	}
+	if params.HeaderName == "" {
+		params.HeaderName = "X-CSRF-Token"
+	}
+
+	ctx.CSRF = &params
+
+	if params.DoubleSubmit {
+		NewRPC(ctx, NewRPCParams{
+			Name:                     CSRF_TOKEN_PROCEDURE,
+			Handler:                  RPC_CSRFToken,
+			AuthorizationNotRequired: true,
+			Description:              "Sets the CSRF cookie and returns the token, send it back in the CSRF header with every call",
+		})
+	}
+}
+
+type CSRFTokenResponse struct {
+	Token  string
+	Header string
+}
+
+func RPC_CSRFToken(requestContext *RequestContext) (response CSRFTokenResponse, problem Problem) {
+	token, err := IssueCSRFToken(requestContext)
+	if err != nil {
+		problem.ErrorID = ERROR_INTERNAL
+		return
+	}
+
+	response.Token = token
+	response.Header = requestContext.Context.CSRF.HeaderName
+	return
+}
+
+// IssueCSRFToken reuses the token from the cookie if there is one, otherwise sets a new cookie
+func IssueCSRFToken(requestContext *RequestContext) (string, error) {
+	params := requestContext.Context.CSRF
+
+	cookie, err := requestContext.Request.Cookie(params.CookieName)
+	if err == nil && cookie.Value != "" {
+		return cookie.Value, nil
+	}
+
+	var random [32]byte
+	_, err = rand.Read(random[:])
+	if err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(random[:])
+
+	http.SetCookie(requestContext.ResponseWriter, &http.Cookie{
+		Name:     params.CookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: false, // Frontend has to read it to put it into the header
+		Secure:   !params.InsecureCookies,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return token, nil
+}
+
+type CredentialSource int
+
+const (
+	CREDENTIAL_UNKNOWN CredentialSource = iota // Custom Authorization that didn't tell, treated as a cookie
+	CREDENTIAL_COOKIE                          // Browsers attach it on their own, CSRF applies
+	CREDENTIAL_HEADER                          // Bearer token or API key header, only the client itself can set it
+)
+
+// IsCookieAuthenticated is false when the credential came from a header, browsers don't attach those on their own so CSRF
+// doesn't apply. Other headers the request carries don't matter, only the credential Authorization accepted.
+func IsCookieAuthenticated(requestContext *RequestContext) bool {
+	return requestContext.Credential != CREDENTIAL_HEADER
+}
+
+// CheckCSRF is done for procedures that require authorization, unless they are CSRFExempt
+func CheckCSRF(ctx *Context, requestContext *RequestContext) (reason string, ok bool) {
+	params := ctx.CSRF
+	procedure := requestContext.Procedure
+	if params == nil || procedure.AuthorizationNotRequired || procedure.CSRFExempt || !IsCookieAuthenticated(requestContext) {
+		return "", true
+	}
+
+	r := requestContext.Request
+
+	if params.CheckOrigin {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			referer, err := url.Parse(r.Header.Get("Referer"))
+			if err == nil && referer.Host != "" {
+				origin = referer.Scheme + "://" + referer.Host
+			}
+		}
+		if origin == "" {
+			return "missing Origin and Referer", false
+		}
+		if !IsAllowedOrigin(params, r, origin) {
+			return "origin not allowed", false
+		}
+	}
+
+	if params.DoubleSubmit {
+		cookie, err := r.Cookie(params.CookieName)
+		if err != nil || cookie.Value == "" {
+			return "missing CSRF cookie", false
+		}
+		header := r.Header.Get(params.HeaderName)
+		if subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) != 1 {
+			return "CSRF token mismatch", false
+		}
+	}
+
+	return "", true
+}
+
+func IsAllowedOrigin(params *CSRFParams, r *http.Request, origin string) bool {
+	parsed, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(parsed.Host, r.Host) {
+		return true
+	}
+
+	for _, allowed := range params.AllowedOrigins {
+		if strings.EqualFold(strings.TrimRight(allowed, "/"), origin) {
+			return true
+		}
+	}
+
+	return false
+}
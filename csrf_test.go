@@ -0,0 +1,44 @@
+package easyframework
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSRFAppliesToCookieSessions(t *testing.T) {
+	ctx := new(Context)
+	err := Initialize(ctx, InitializeParams{Storage: NewMemoryStorage(), Sessions: &SessionParams{}, CSRF: &CSRFParams{DoubleSubmit: true}})
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	session, err := NewSession(ctx, NewID[User](), "")
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+
+	authorize := func(header string, cookie bool) *RequestContext {
+		request := httptest.NewRequest("POST", "/rpc/Test", nil)
+		if header != "" {
+			request.Header.Set("Authorization", header)
+		}
+		if cookie {
+			request.AddCookie(&http.Cookie{Name: ctx.Sessions.CookieName, Value: session.ID.String()})
+		}
+		requestContext := &RequestContext{Context: ctx, Procedure: &Procedure{}, Request: request, ResponseWriter: httptest.NewRecorder()}
+		if !SessionAuthorization(requestContext, requestContext.ResponseWriter, request) {
+			t.Fatalf("Session was not accepted, Authorization %q, cookie %v", header, cookie)
+		}
+		return requestContext
+	}
+
+	if _, ok := CheckCSRF(ctx, authorize("", true)); ok {
+		t.Fatalf("Cookie session passed without a CSRF token")
+	}
+	if _, ok := CheckCSRF(ctx, authorize("Basic eDp5", true)); ok {
+		t.Fatalf("Unrelated Authorization header let a cookie session skip CSRF")
+	}
+	if _, ok := CheckCSRF(ctx, authorize("Bearer "+session.ID.String(), false)); !ok {
+		t.Fatalf("Bearer session was checked for CSRF")
+	}
+}
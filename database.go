@@ -1,7 +1,7 @@
 package easyframework
 
 import (
-	"github.com/boltdb/bolt"
+	"bytes"
 	"log"
 )
 
@@ -37,7 +37,7 @@ func (v BucketNotFoundError) Error() string {
 	return "Bucket not found"
 }
 
-func GetBucket(tx *bolt.Tx, id BucketID) (*bolt.Bucket, error) {
+func GetBucket(tx Tx, id BucketID) (Bucket, error) {
 	bucket := tx.Bucket([]byte(id))
 	if bucket == nil {
 		return nil, BucketNotFoundError{}
@@ -48,14 +48,14 @@ func GetBucket(tx *bolt.Tx, id BucketID) (*bolt.Bucket, error) {
 
 func GetByID[T any](ctx *Context, bucketID BucketID, ID ID128, result *T) bool {
 	var found bool
-	ctx.Database.View(func(tx *bolt.Tx) error {
+	ctx.Database.View(func(tx Tx) error {
 		bucket := tx.Bucket([]byte(bucketID))
 		if bucket == nil {
 			return BucketNotFoundError{}
 		}
 
 		_result := bucket.Get(ID[:])
-		if _result == nil {
+		if _result == nil || IsExpired(bucket, ID) {
 			return nil
 		}
 
@@ -73,43 +73,138 @@ func GetByID[T any](ctx *Context, bucketID BucketID, ID ID128, result *T) bool {
 }
 
 func InsertByID[T any](ctx *Context, bucketID BucketID, ID ID128, value *T) error {
-	err := ctx.Database.Update(func(tx *bolt.Tx) error {
+	err := ctx.Database.Update(func(tx Tx) error {
 		bucket := tx.Bucket([]byte(bucketID))
 		if bucket == nil {
 			return BucketNotFoundError{}
 		}
 
-		_result, err := Pack(value)
-		if err != nil {
-			return err
-		}
-
-		return bucket.Put(ID[:], _result)
+		return Insert(bucket, ID, value)
 	})
 
 	return err
 }
 
-func Insert[T any](bucket *bolt.Bucket, ID ID128, value *T) error {
+func Insert[T any](bucket Bucket, ID ID128, value *T) error {
 	binaryData, err := Pack(value)
 	if err != nil {
 		return err
 	}
+
+	bucketID, watched := watchedBucket(bucket)
+	if HasIndexes[T]() || watched {
+		oldValue, err := getOld[T](bucket, ID)
+		if err != nil {
+			return err
+		}
+		err = updateIndexes(bucket, ID, oldValue, value)
+		if err != nil {
+			return err
+		}
+		if watched {
+			err = recordChange(bucket, bucketID, ID, oldValue, value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = clearExpiry(bucket, ID)
+	if err != nil {
+		return err
+	}
+
 	err = bucket.Put(ID[:], binaryData)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return bumpVersion(bucket, ID)
 }
 
-func Iterate[V any](bucket *bolt.Bucket, iteratorProcedure func(key ID128, value *V) bool) {
+// Delete is the counterpart of Insert, T is the type of the record being removed
+func Delete[T any](bucket Bucket, ID ID128) error {
+	bucketID, watched := watchedBucket(bucket)
+	if HasIndexes[T]() || watched {
+		oldValue, err := getOld[T](bucket, ID)
+		if err != nil {
+			return err
+		}
+		err = updateIndexes[T](bucket, ID, oldValue, nil)
+		if err != nil {
+			return err
+		}
+		if watched {
+			err = recordChange[T](bucket, bucketID, ID, oldValue, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err := clearExpiry(bucket, ID)
+	if err != nil {
+		return err
+	}
+
+	err = removeVersion(bucket, ID)
+	if err != nil {
+		return err
+	}
+
+	return bucket.Delete(ID[:])
+}
+
+// getOld returns the value that is currently stored, nil if there is none
+func getOld[T any](bucket Bucket, ID ID128) (*T, error) {
+	data := bucket.Get(ID[:])
+	if data == nil {
+		return nil, nil
+	}
+
+	var oldValue T
+	err := Unpack(data, &oldValue)
+	if err != nil {
+		return nil, err
+	}
+	return &oldValue, nil
+}
+
+func Iterate[V any](bucket Bucket, iteratorProcedure func(key ID128, value *V) bool) {
+	expiry := NewExpiryChecker(bucket)
 	cursor := bucket.Cursor()
 	for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+		if value == nil || expiry.IsExpired(key) { // Nested bucket (indexes), or expired and not swept yet
+			continue
+		}
+
 		var theStruct V
 		err := Unpack(value, &theStruct)
-		if err != nil {
+		if err != nil { // Skipped, CheckIntegrity reports it
+			log.Printf("Unpack FAILED for ID %v, reason: %v", key, err)
+			continue
+		}
+
+		if !iteratorProcedure(ID128(key), &theStruct) {
+			break
+		}
+	}
+}
+
+// IterateRange goes over records with from <= ID < to in key order. With sortable IDs that is a creation time range, see SortableID128AtTime.
+func IterateRange[V any](bucket Bucket, from, to ID128, iteratorProcedure func(key ID128, value *V) bool) {
+	expiry := NewExpiryChecker(bucket)
+	cursor := bucket.Cursor()
+	for key, value := cursor.Seek(from[:]); key != nil && bytes.Compare(key, to[:]) < 0; key, value = cursor.Next() {
+		if value == nil || expiry.IsExpired(key) {
+			continue
+		}
+
+		var theStruct V
+		err := Unpack(value, &theStruct)
+		if err != nil { // Skipped, CheckIntegrity reports it
 			log.Printf("Unpack FAILED for ID %v, reason: %v", key, err)
+			continue
 		}
 
 		if !iteratorProcedure(ID128(key), &theStruct) {
@@ -118,14 +213,20 @@ func Iterate[V any](bucket *bolt.Bucket, iteratorProcedure func(key ID128, value
 	}
 }
 
-func IterateCollect[V any](bucket *bolt.Bucket, iteratorProcedure func(key ID128, value *V) bool) []V {
+func IterateCollect[V any](bucket Bucket, iteratorProcedure func(key ID128, value *V) bool) []V {
+	expiry := NewExpiryChecker(bucket)
 	cursor := bucket.Cursor()
 	var result []V
 	for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+		if value == nil || expiry.IsExpired(key) { // Nested bucket (indexes), or expired and not swept yet
+			continue
+		}
+
 		var theStruct V
 		err := Unpack(value, &theStruct)
-		if err != nil {
+		if err != nil { // Skipped, CheckIntegrity reports it
 			log.Printf("Unpack FAILED for ID %v, reason: %v", key, err)
+			continue
 		}
 
 		if !iteratorProcedure(ID128(key), &theStruct) {
@@ -138,14 +239,20 @@ func IterateCollect[V any](bucket *bolt.Bucket, iteratorProcedure func(key ID128
 	return result
 }
 
-func IterateCollectAll[V any](bucket *bolt.Bucket) []V {
+func IterateCollectAll[V any](bucket Bucket) []V {
+	expiry := NewExpiryChecker(bucket)
 	cursor := bucket.Cursor()
 	var result []V
 	for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+		if value == nil || expiry.IsExpired(key) { // Nested bucket (indexes), or expired and not swept yet
+			continue
+		}
+
 		var theStruct V
 		err := Unpack(value, &theStruct)
-		if err != nil {
+		if err != nil { // Skipped, CheckIntegrity reports it
 			log.Printf("Unpack FAILED for ID %v, reason: %v", key, err)
+			continue
 		}
 
 		result = append(result, theStruct)
@@ -154,28 +261,56 @@ func IterateCollectAll[V any](bucket *bolt.Bucket) []V {
 	return result
 }
 
-func IterateRemove[V any](bucket *bolt.Bucket, iteratorProcedure func(key ID128, value *V) bool) {
+func IterateRemove[V any](bucket Bucket, iteratorProcedure func(key ID128, value *V) bool) {
+	bucketID, watched := watchedBucket(bucket)
+	expiry := NewExpiryChecker(bucket)
 	cursor := bucket.Cursor()
 	for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+		if value == nil || expiry.IsExpired(key) { // Nested bucket (indexes), or expired and not swept yet
+			continue
+		}
+
 		var theStruct V
 		err := Unpack(value, &theStruct)
-		if err != nil {
+		if err != nil { // Skipped, CheckIntegrity reports it
 			log.Printf("Unpack FAILED for ID %v, reason: %v", key, err)
+			continue
 		}
 
 		if iteratorProcedure(ID128(key), &theStruct) {
+			if HasIndexes[V]() {
+				err := updateIndexes[V](bucket, ID128(key), &theStruct, nil)
+				if err != nil {
+					log.Printf("Failed to update indexes for ID %v, reason: %v", key, err)
+				}
+			}
+			if watched {
+				removed := theStruct
+				err := recordChange(bucket, bucketID, ID128(key), &removed, nil)
+				if err != nil {
+					log.Printf("Failed to record change for ID %v, reason: %v", key, err)
+				}
+			}
+			clearExpiry(bucket, ID128(key))
+			removeVersion(bucket, ID128(key))
 			bucket.Delete(key)
 		}
 	}
 }
 
-func IterateFind[V any](bucket *bolt.Bucket, target *V, iteratorProcedure func(key ID128, value *V) bool) (found bool) {
+func IterateFind[V any](bucket Bucket, target *V, iteratorProcedure func(key ID128, value *V) bool) (found bool) {
+	expiry := NewExpiryChecker(bucket)
 	cursor := bucket.Cursor()
 	for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+		if value == nil || expiry.IsExpired(key) { // Nested bucket (indexes), or expired and not swept yet
+			continue
+		}
+
 		var theStruct V
 		err := Unpack(value, &theStruct)
-		if err != nil {
+		if err != nil { // Skipped, CheckIntegrity reports it
 			log.Printf("Unpack FAILED for ID %v, reason: %v", key, err)
+			continue
 		}
 
 		if iteratorProcedure(ID128(key), &theStruct) {
@@ -188,10 +323,10 @@ func IterateFind[V any](bucket *bolt.Bucket, target *V, iteratorProcedure func(k
 	return
 }
 
-func WriteTx(ctx *Context) (*bolt.Tx, error) {
+func WriteTx(ctx *Context) (Tx, error) {
 	return ctx.Database.Begin(true)
 }
 
-func ReadTx(ctx *Context) (*bolt.Tx, error) {
+func ReadTx(ctx *Context) (Tx, error) {
 	return ctx.Database.Begin(false)
 }
@@ -48,6 +48,10 @@ func _TypeToMarkdown(value reflect.Type, sb *strings.Builder, indent int, newlin
 		sb.WriteString("<b>timestamp</b>")
 	} else if value.Name() == "Time" {
 		sb.WriteString("<b>time</b>")
+	} else if strings.HasPrefix(value.Name(), "ID[") && value.PkgPath() == reflect.TypeOf(ID128{}).PkgPath() {
+		entity := strings.TrimSuffix(value.Name()[len("ID["):], "]")
+		entity = entity[strings.LastIndex(entity, ".")+1:]
+		sb.WriteString(fmt.Sprintf("<b>ID128</b> (%v)", entity))
 	} else if value.Kind() == reflect.Interface && value.NumMethod() == 0 {
 		sb.WriteString("<b>any</b>")
 	} else if value.Kind() == reflect.Struct {
@@ -86,6 +90,9 @@ func _TypeToMarkdown(value reflect.Type, sb *strings.Builder, indent int, newlin
 				if ourTags.IsARequiredField {
 					sb.WriteString(" (required)")
 				}
+				if ourTags.IsAPassword {
+					sb.WriteString(" (password)")
+				}
 
 				description := ParseFieldDescription(field)
 				if description != "" {
@@ -128,6 +135,7 @@ func _TypeToMarkdown(value reflect.Type, sb *strings.Builder, indent int, newlin
 
 type OurTags struct {
 	IsARequiredField bool
+	IsAPassword      bool // Never logged, documented with a value or returned in a response
 }
 
 func ParseOurTags(field reflect.StructField) OurTags {
@@ -137,6 +145,9 @@ func ParseOurTags(field reflect.StructField) OurTags {
 	_, ourTags.IsARequiredField = Search(tags, func(tag string) bool {
 		return tag == "required"
 	})
+	_, ourTags.IsAPassword = Search(tags, func(tag string) bool {
+		return tag == "password"
+	})
 
 	return ourTags
 }
@@ -0,0 +1,707 @@
+package easyframework
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+/*
+Encryption at rest, AES-GCM, configured two ways:
+	per field    string fields tagged `encrypt:"true"` are encrypted by Pack and decrypted by Unpack, wherever the
+	             struct is stored. Only top level fields of the packed struct, empty strings stay empty.
+	per bucket   EncryptionParams.Buckets, every value of the bucket is encrypted by the storage. Nested buckets
+	             (expiry, versions) are not, and neither are keys. Indexes would keep values in plain text in their
+	             keys, types with indexes can't be stored in encrypted buckets, see EncryptedIndexError. Add
+	             BUCKET_CHANGES if the change log keeps values of encrypted buckets.
+
+Envelope of an encrypted value:
+	"\xefEC"        magic, Pack output of a struct never starts with 0xef
+	key ID length   1 byte
+	key ID
+	nonce           12 bytes
+	ciphertext      the last 16 bytes are the GCM tag
+Additional data binds the ciphertext to its place. For buckets it is "<bucket>\x00<key>", a value can't be copied to
+a different record. For fields it is "field:<id>", Pack doesn't know the record: a value can't be copied to a field
+with a different ID, but it can to the same field of another record or type. Use bucket encryption where that matters.
+
+Writes always use the current key of the KeyProvider, older keys are only needed to read what wasn't written since.
+That is the rotation: add a new key as current, keep the old ones until ReencryptBucket went over every bucket, or
+until every record was written once.
+Values without the magic are returned as they are, so encryption can be enabled on an existing database.
+*/
+
+const ENVELOPE_MAGIC = "\xefEC"
+
+const ENCRYPTION_NONCE_SIZE = 12
+
+// KeyProvider gives keys by ID, keys are 16, 24 or 32 bytes for AES-128, AES-192 and AES-256
+type KeyProvider interface {
+	CurrentKeyID() string
+	Key(keyID string) ([]byte, error)
+}
+
+type EncryptionParams struct {
+	Keys    KeyProvider
+	Buckets []BucketID // Buckets encrypted as a whole, fields tagged `encrypt:"true"` are encrypted in every bucket
+}
+
+type KeyNotFoundError struct {
+	KeyID string
+}
+
+func (v KeyNotFoundError) Error() string {
+	return fmt.Sprintf("Encryption key %q not found", v.KeyID)
+}
+
+type EncryptionNotConfiguredError struct{}
+
+func (v EncryptionNotConfiguredError) Error() string {
+	return "Encrypted data, but encryption is not configured, see InitializeParams.Encryption"
+}
+
+type EncryptedIndexError struct {
+	Bucket string
+	Index  string
+}
+
+func (v EncryptedIndexError) Error() string {
+	return fmt.Sprintf("Bucket %v is encrypted, index %v would keep values in plain text", v.Bucket, v.Index)
+}
+
+type DecryptionError struct {
+	KeyID string
+}
+
+func (v DecryptionError) Error() string {
+	return fmt.Sprintf("Decryption with key %q FAILED, the data is corrupted or was moved", v.KeyID)
+}
+
+/*
+KeyRing is a KeyProvider over a fixed set of keys, its text form is "<key ID>:<base64 key>" entries separated by
+commas or new lines, the first one is current:
+
+	2024-06:q2l5mF0...,2024-01:Zm9vYmFy...
+*/
+type KeyRing struct {
+	Current string
+	Keys    map[string][]byte
+}
+
+func (ring *KeyRing) CurrentKeyID() string {
+	return ring.Current
+}
+
+func (ring *KeyRing) Key(keyID string) ([]byte, error) {
+	key, ok := ring.Keys[keyID]
+	if !ok {
+		return nil, KeyNotFoundError{KeyID: keyID}
+	}
+	return key, nil
+}
+
+func ParseKeyRing(text string) (*KeyRing, error) {
+	ring := &KeyRing{Keys: make(map[string][]byte)}
+	entries := strings.FieldsFunc(text, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r'
+	})
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		keyID, encoded, ok := strings.Cut(entry, ":")
+		if !ok || keyID == "" || len(keyID) > 255 {
+			return nil, fmt.Errorf("Key ring entry must be <key ID>:<base64 key>, key ID of at most 255 bytes")
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("Key %v is not base64: %w", keyID, err)
+		}
+		if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+			return nil, fmt.Errorf("Key %v is %v bytes, AES needs 16, 24 or 32", keyID, len(key))
+		}
+		if _, exists := ring.Keys[keyID]; exists {
+			return nil, fmt.Errorf("Key %v is in the key ring twice", keyID)
+		}
+
+		ring.Keys[keyID] = key
+		if ring.Current == "" {
+			ring.Current = keyID
+		}
+	}
+
+	if ring.Current == "" {
+		return nil, errors.New("Key ring is empty")
+	}
+	return ring, nil
+}
+
+func KeyRingFromEnv(variable string) (*KeyRing, error) {
+	text, ok := os.LookupEnv(variable)
+	if !ok {
+		return nil, fmt.Errorf("Environment variable %v is not set", variable)
+	}
+	return ParseKeyRing(text)
+}
+
+// KeyRingFromFile reads a key ring file, one entry per line, lines starting with # are comments
+func KeyRingFromFile(path string) (*KeyRing, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeyRing(string(text))
+}
+
+// GenerateEncryptionKey returns a random AES-256 key in the base64 form key rings use
+func GenerateEncryptionKey() string {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+type encryptor struct {
+	keys  KeyProvider
+	mutex sync.Mutex
+	aeads map[string]cipher.AEAD
+}
+
+func newEncryptor(keys KeyProvider) *encryptor {
+	return &encryptor{keys: keys, aeads: make(map[string]cipher.AEAD)}
+}
+
+func (e *encryptor) aead(keyID string) (cipher.AEAD, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	aead, ok := e.aeads[keyID]
+	if ok {
+		return aead, nil
+	}
+
+	key, err := e.keys.Key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("Key %v: %w", keyID, err)
+	}
+	aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	e.aeads[keyID] = aead
+	return aead, nil
+}
+
+func (e *encryptor) seal(plaintext []byte, additionalData []byte) ([]byte, error) {
+	keyID := e.keys.CurrentKeyID()
+	aead, err := e.aead(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	header := len(ENVELOPE_MAGIC) + 1 + len(keyID)
+	envelope := make([]byte, header+ENCRYPTION_NONCE_SIZE, header+ENCRYPTION_NONCE_SIZE+len(plaintext)+aead.Overhead())
+	copy(envelope, ENVELOPE_MAGIC)
+	envelope[len(ENVELOPE_MAGIC)] = byte(len(keyID))
+	copy(envelope[len(ENVELOPE_MAGIC)+1:], keyID)
+	nonce := envelope[header:]
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(envelope, nonce, plaintext, additionalData), nil
+}
+
+func (e *encryptor) open(envelope []byte, additionalData []byte) ([]byte, error) {
+	keyID, ok := EnvelopeKeyID(envelope)
+	if !ok {
+		return nil, DecryptionError{}
+	}
+	aead, err := e.aead(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	header := len(ENVELOPE_MAGIC) + 1 + len(keyID)
+	if len(envelope) < header+ENCRYPTION_NONCE_SIZE+aead.Overhead() {
+		return nil, DecryptionError{KeyID: keyID}
+	}
+	nonce := envelope[header : header+ENCRYPTION_NONCE_SIZE]
+	plaintext, err := aead.Open(nil, nonce, envelope[header+ENCRYPTION_NONCE_SIZE:], additionalData)
+	if err != nil {
+		return nil, DecryptionError{KeyID: keyID}
+	}
+	return plaintext, nil
+}
+
+// IsDecryptionError tells records that need a missing or different key from broken ones, they must not be dropped
+func IsDecryptionError(err error) bool {
+	var decryptionError DecryptionError
+	var keyNotFound KeyNotFoundError
+	var notConfigured EncryptionNotConfiguredError
+	return errors.As(err, &decryptionError) || errors.As(err, &keyNotFound) || errors.As(err, &notConfigured)
+}
+
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(ENVELOPE_MAGIC))
+}
+
+// EnvelopeKeyID returns the ID of the key the value was encrypted with
+func EnvelopeKeyID(data []byte) (string, bool) {
+	if !IsEncrypted(data) || len(data) < len(ENVELOPE_MAGIC)+1 {
+		return "", false
+	}
+	length := int(data[len(ENVELOPE_MAGIC)])
+	if len(data) < len(ENVELOPE_MAGIC)+1+length {
+		return "", false
+	}
+	return string(data[len(ENVELOPE_MAGIC)+1 : len(ENVELOPE_MAGIC)+1+length]), true
+}
+
+// Field encryption is global like the format itself, Pack and Unpack don't know the Context
+var fieldEncryptorMutex sync.RWMutex
+var fieldEncryptor *encryptor
+
+var encryptedFieldsMutex sync.RWMutex
+var encryptedFieldsCache = make(map[reflect.Type][]encryptedField)
+
+type encryptedField struct {
+	index          int
+	additionalData []byte
+}
+
+func InitializeEncryption(ctx *Context, params EncryptionParams) error {
+	if params.Keys == nil {
+		return errors.New("EncryptionParams.Keys is required")
+	}
+	e := newEncryptor(params.Keys)
+	_, err := e.aead(params.Keys.CurrentKeyID()) // Fail now rather than on the first write
+	if err != nil {
+		return err
+	}
+
+	fieldEncryptorMutex.Lock()
+	fieldEncryptor = e
+	fieldEncryptorMutex.Unlock()
+
+	ctx.Encryption = &params
+	if len(params.Buckets) > 0 && ctx.Database != nil {
+		ctx.Database = NewEncryptedStorage(ctx.Database, params.Keys, params.Buckets)
+	}
+	return nil
+}
+
+func currentFieldEncryptor() *encryptor {
+	fieldEncryptorMutex.RLock()
+	defer fieldEncryptorMutex.RUnlock()
+	return fieldEncryptor
+}
+
+func encryptedFields(typeof reflect.Type) []encryptedField {
+	if typeof.Kind() != reflect.Struct {
+		return nil
+	}
+
+	encryptedFieldsMutex.RLock()
+	fields, ok := encryptedFieldsCache[typeof]
+	encryptedFieldsMutex.RUnlock()
+	if ok {
+		return fields
+	}
+
+	for i := 0; i < typeof.NumField(); i += 1 {
+		field := typeof.Field(i)
+		if field.Tag.Get("encrypt") != "true" {
+			continue
+		}
+		if field.Type.Kind() != reflect.String || field.Tag.Get("id") == "" {
+			log.Printf("Struct %v: field %v is tagged encrypt, only stored string fields can be encrypted", typeof.Name(), field.Name)
+			panic("Failed to preprocess struct!")
+		}
+		if _, indexed := field.Tag.Lookup("index"); indexed {
+			log.Printf("Struct %v: field %v is tagged encrypt and index, the index would keep it in plain text", typeof.Name(), field.Name)
+			panic("Failed to preprocess struct!")
+		}
+		fields = append(fields, encryptedField{index: i, additionalData: []byte("field:" + field.Tag.Get("id"))})
+	}
+
+	encryptedFieldsMutex.Lock()
+	encryptedFieldsCache[typeof] = fields
+	encryptedFieldsMutex.Unlock()
+	return fields
+}
+
+// encryptFields returns a copy of the struct with encrypted fields replaced by envelopes, the value itself is not changed
+func encryptFields(typeof reflect.Type, value reflect.Value, fields []encryptedField) (reflect.Value, error) {
+	e := currentFieldEncryptor()
+	if e == nil {
+		return value, EncryptionNotConfiguredError{}
+	}
+
+	encrypted := reflect.New(typeof).Elem()
+	encrypted.Set(value)
+	for _, field := range fields {
+		plaintext := encrypted.Field(field.index).String()
+		if plaintext == "" {
+			continue
+		}
+		envelope, err := e.seal([]byte(plaintext), field.additionalData)
+		if err != nil {
+			return value, err
+		}
+		encrypted.Field(field.index).SetString(string(envelope))
+	}
+	return encrypted, nil
+}
+
+// decryptFields decrypts in place, fields that are not envelopes were written before encryption was enabled and stay
+func decryptFields(value reflect.Value, fields []encryptedField) error {
+	for _, field := range fields {
+		data := value.Field(field.index).String()
+		if !IsEncrypted([]byte(data)) {
+			continue
+		}
+
+		e := currentFieldEncryptor()
+		if e == nil {
+			return EncryptionNotConfiguredError{}
+		}
+		plaintext, err := e.open([]byte(data), field.additionalData)
+		if err != nil {
+			return err
+		}
+		value.Field(field.index).SetString(string(plaintext))
+	}
+	return nil
+}
+
+// NewEncryptedStorage encrypts values of the buckets on the way into storage and decrypts them on the way out
+func NewEncryptedStorage(storage Storage, keys KeyProvider, buckets []BucketID) Storage {
+	encrypted := &encryptedStorage{
+		storage:   storage,
+		encryptor: newEncryptor(keys),
+		buckets:   make(map[string]bool),
+	}
+	for _, bucketID := range buckets {
+		encrypted.buckets[string(bucketID)] = true
+	}
+	return encrypted
+}
+
+type encryptedStorage struct {
+	storage   Storage
+	encryptor *encryptor
+	buckets   map[string]bool
+}
+
+type encryptedTx struct {
+	Tx
+	storage *encryptedStorage
+}
+
+type encryptedBucket struct {
+	bucket    Bucket
+	parent    *encryptedBucket // nil for top level buckets
+	tx        *encryptedTx
+	encrypted bool // false for buckets that are only wrapped to keep Tx() and nested buckets going through the storage
+}
+
+type encryptedCursor struct {
+	Cursor
+	bucket *encryptedBucket
+}
+
+func (storage *encryptedStorage) Begin(writable bool) (Tx, error) {
+	tx, err := storage.storage.Begin(writable)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedTx{Tx: tx, storage: storage}, nil
+}
+
+func (storage *encryptedStorage) View(procedure func(tx Tx) error) error {
+	return storage.storage.View(func(tx Tx) error {
+		return procedure(&encryptedTx{Tx: tx, storage: storage})
+	})
+}
+
+func (storage *encryptedStorage) Update(procedure func(tx Tx) error) error {
+	return storage.storage.Update(func(tx Tx) error {
+		return procedure(&encryptedTx{Tx: tx, storage: storage})
+	})
+}
+
+func (storage *encryptedStorage) Batch(procedure func(tx Tx) error) error {
+	return storage.storage.Batch(func(tx Tx) error {
+		return procedure(&encryptedTx{Tx: tx, storage: storage})
+	})
+}
+
+func (storage *encryptedStorage) Close() error {
+	return storage.storage.Close()
+}
+
+func (tx *encryptedTx) wrap(bucket Bucket, parent *encryptedBucket, encrypted bool) Bucket {
+	if bucket == nil {
+		return nil
+	}
+	return &encryptedBucket{bucket: bucket, parent: parent, tx: tx, encrypted: encrypted}
+}
+
+func (tx *encryptedTx) Bucket(name []byte) Bucket {
+	return tx.wrap(tx.Tx.Bucket(name), nil, tx.storage.buckets[string(name)])
+}
+
+func (tx *encryptedTx) CreateBucket(name []byte) (Bucket, error) {
+	bucket, err := tx.Tx.CreateBucket(name)
+	return tx.wrap(bucket, nil, tx.storage.buckets[string(name)]), err
+}
+
+func (tx *encryptedTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	bucket, err := tx.Tx.CreateBucketIfNotExists(name)
+	return tx.wrap(bucket, nil, tx.storage.buckets[string(name)]), err
+}
+
+func (tx *encryptedTx) ForEach(procedure func(name []byte, bucket Bucket) error) error {
+	return tx.Tx.ForEach(func(name []byte, bucket Bucket) error {
+		return procedure(name, tx.wrap(bucket, nil, tx.storage.buckets[string(name)]))
+	})
+}
+
+// Size and WriteTo copy the database as it is stored, encrypted
+func (tx *encryptedTx) Size() int64 {
+	snapshotTx, ok := tx.Tx.(SnapshotTx)
+	if !ok {
+		return 0
+	}
+	return snapshotTx.Size()
+}
+
+func (tx *encryptedTx) WriteTo(w io.Writer) (int64, error) {
+	snapshotTx, ok := tx.Tx.(SnapshotTx)
+	if !ok {
+		return 0, StorageNotSupportedError{Feature: "snapshots"}
+	}
+	return snapshotTx.WriteTo(w)
+}
+
+func (bucket *encryptedBucket) additionalData(key []byte) []byte {
+	name := bucket.bucket.Name()
+	additionalData := make([]byte, 0, len(name)+1+len(key))
+	additionalData = append(additionalData, name...)
+	additionalData = append(additionalData, 0)
+	return append(additionalData, key...)
+}
+
+// decrypt returns values it can't decrypt as they are, Unpack fails on them with DecryptionError
+func (bucket *encryptedBucket) decrypt(key, value []byte) []byte {
+	if !bucket.encrypted || value == nil || !IsEncrypted(value) {
+		return value
+	}
+	plaintext, err := bucket.tx.storage.encryptor.open(value, bucket.additionalData(key))
+	if err != nil {
+		log.Printf("Decryption FAILED for %x in %v: %v", key, string(bucket.bucket.Name()), err)
+		return value
+	}
+	return plaintext
+}
+
+// isEncryptedBucket tells if values of the bucket are encrypted by the storage
+func isEncryptedBucket(bucket Bucket) bool {
+	encrypted, ok := bucket.(*encryptedBucket)
+	return ok && encrypted.encrypted
+}
+
+func (bucket *encryptedBucket) Name() []byte {
+	return bucket.bucket.Name()
+}
+
+func (bucket *encryptedBucket) Parent() Bucket {
+	if bucket.parent == nil {
+		return nil
+	}
+	return bucket.parent
+}
+
+func (bucket *encryptedBucket) Tx() Tx {
+	return bucket.tx
+}
+
+func (bucket *encryptedBucket) Writable() bool {
+	return bucket.bucket.Writable()
+}
+
+func (bucket *encryptedBucket) Delete(key []byte) error {
+	return bucket.bucket.Delete(key)
+}
+
+func (bucket *encryptedBucket) NextSequence() (uint64, error) {
+	return bucket.bucket.NextSequence()
+}
+
+func (bucket *encryptedBucket) DeleteBucket(name []byte) error {
+	return bucket.bucket.DeleteBucket(name)
+}
+
+func (bucket *encryptedBucket) Get(key []byte) []byte {
+	return bucket.decrypt(key, bucket.bucket.Get(key))
+}
+
+func (bucket *encryptedBucket) Put(key []byte, value []byte) error {
+	if !bucket.encrypted {
+		return bucket.bucket.Put(key, value)
+	}
+	envelope, err := bucket.tx.storage.encryptor.seal(value, bucket.additionalData(key))
+	if err != nil {
+		return err
+	}
+	return bucket.bucket.Put(key, envelope)
+}
+
+func (bucket *encryptedBucket) Cursor() Cursor {
+	return &encryptedCursor{Cursor: bucket.bucket.Cursor(), bucket: bucket}
+}
+
+func (bucket *encryptedBucket) ForEach(procedure func(key, value []byte) error) error {
+	return bucket.bucket.ForEach(func(key, value []byte) error {
+		return procedure(key, bucket.decrypt(key, value))
+	})
+}
+
+func (bucket *encryptedBucket) Bucket(name []byte) Bucket {
+	return bucket.tx.wrap(bucket.bucket.Bucket(name), bucket, false)
+}
+
+func (bucket *encryptedBucket) CreateBucket(name []byte) (Bucket, error) {
+	nested, err := bucket.bucket.CreateBucket(name)
+	return bucket.tx.wrap(nested, bucket, false), err
+}
+
+func (bucket *encryptedBucket) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	nested, err := bucket.bucket.CreateBucketIfNotExists(name)
+	return bucket.tx.wrap(nested, bucket, false), err
+}
+
+func (cursor *encryptedCursor) First() ([]byte, []byte) {
+	key, value := cursor.Cursor.First()
+	return key, cursor.bucket.decrypt(key, value)
+}
+
+func (cursor *encryptedCursor) Last() ([]byte, []byte) {
+	key, value := cursor.Cursor.Last()
+	return key, cursor.bucket.decrypt(key, value)
+}
+
+func (cursor *encryptedCursor) Next() ([]byte, []byte) {
+	key, value := cursor.Cursor.Next()
+	return key, cursor.bucket.decrypt(key, value)
+}
+
+func (cursor *encryptedCursor) Prev() ([]byte, []byte) {
+	key, value := cursor.Cursor.Prev()
+	return key, cursor.bucket.decrypt(key, value)
+}
+
+func (cursor *encryptedCursor) Seek(seek []byte) ([]byte, []byte) {
+	key, value := cursor.Cursor.Seek(seek)
+	return key, cursor.bucket.decrypt(key, value)
+}
+
+/*
+ReencryptBucket rewrites every record of the bucket with the current key, run it for every encrypted bucket after
+a new key became current, then the old key can be dropped. Records of registered types are unpacked and packed again,
+which moves encrypted fields to the current key too. Indexes, versions and subscribers are not touched, values stay the same.
+*/
+func ReencryptBucket(ctx *Context, bucketID BucketID) (rewritten int, err error) {
+	bucketType, registered := lookupBucketType(bucketID)
+
+	err = ctx.Database.Update(func(tx Tx) error {
+		bucket, err := GetBucket(tx, bucketID)
+		if err != nil {
+			return err
+		}
+
+		var keys [][]byte
+		cursor := bucket.Cursor()
+		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+			if value != nil {
+				keys = append(keys, append([]byte(nil), key...))
+			}
+		}
+
+		for _, key := range keys {
+			data := bucket.Get(key)
+			if registered {
+				value, err := bucketType.unpack(data)
+				if err != nil {
+					return fmt.Errorf("Record %x can't be unpacked, see CheckIntegrity: %w", key, err)
+				}
+				data, err = bucketType.pack(value)
+				if err != nil {
+					return err
+				}
+			} else if IsEncrypted(data) { // Still an envelope, decrypt failed on it
+				keyID, _ := EnvelopeKeyID(data)
+				return fmt.Errorf("Record %x: %w", key, DecryptionError{KeyID: keyID})
+			} else {
+				data = append([]byte(nil), data...) // What Get returns is only valid until the bucket changes
+			}
+
+			err = bucket.Put(key, data)
+			if err != nil {
+				return err
+			}
+			rewritten += 1
+		}
+		return nil
+	})
+	return rewritten, err
+}
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "reencrypt",
+		Usage:       "<bucket...>",
+		Description: "Rewrite records with the current encryption key, the server must be stopped",
+		Run: func(params InitializeParams, args []string) error {
+			if len(args) == 0 {
+				return errors.New("Usage: reencrypt <bucket...>")
+			}
+			if params.Encryption == nil {
+				return errors.New("reencrypt requires Encryption")
+			}
+
+			ctx, err := openDatabaseForCommand(params)
+			if err != nil {
+				return err
+			}
+			defer ctx.Database.Close()
+
+			for _, bucket := range args {
+				rewritten, err := ReencryptBucket(ctx, BucketID(bucket))
+				if err != nil {
+					return fmt.Errorf("%v: %w", bucket, err)
+				}
+				fmt.Fprintf(os.Stderr, "Rewrote %v records of %v\n", rewritten, bucket)
+			}
+			return nil
+		},
+	})
+}
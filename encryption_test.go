@@ -0,0 +1,149 @@
+package easyframework
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type encryptionTestRecord struct {
+	Name   string `id:"1"`
+	Secret string `id:"2" encrypt:"true"`
+}
+
+type encryptionTestIndexed struct {
+	Name string `id:"1" index:"encryption_test_name"`
+}
+
+type encryptionTestEncryptedIndex struct {
+	Secret string `id:"1" encrypt:"true" index:"encryption_test_secret"`
+}
+
+func newEncryptionTestContext(t *testing.T, storage Storage, keys string) *Context {
+	t.Helper()
+	ring, err := ParseKeyRing(keys)
+	if err != nil {
+		t.Fatalf("ParseKeyRing: %v", err)
+	}
+
+	ctx := new(Context)
+	err = Initialize(ctx, InitializeParams{Storage: storage, Encryption: &EncryptionParams{Keys: ring, Buckets: []BucketID{"encrypted_test"}}})
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	for _, bucketID := range []BucketID{"encrypted_test", "plain_test"} {
+		err = NewBucket(ctx, bucketID)
+		if err != nil {
+			t.Fatalf("NewBucket: %v", err)
+		}
+	}
+	return ctx
+}
+
+// storedValue reads the value as it is in the storage under the encryption
+func storedValue(t *testing.T, storage Storage, bucketID BucketID, ID ID128) []byte {
+	t.Helper()
+	var value []byte
+	storage.View(func(tx Tx) error {
+		value = append(value, tx.Bucket([]byte(bucketID)).Get(ID[:])...)
+		return nil
+	})
+	return value
+}
+
+func TestFieldEncryption(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := newEncryptionTestContext(t, storage, "k1:"+GenerateEncryptionKey())
+
+	ID := NewID128()
+	err := InsertByID(ctx, "plain_test", ID, &encryptionTestRecord{Name: "visible", Secret: "hunter2"})
+	if err != nil {
+		t.Fatalf("InsertByID: %v", err)
+	}
+	stored := storedValue(t, storage, "plain_test", ID)
+	if bytes.Contains(stored, []byte("hunter2")) || !bytes.Contains(stored, []byte("visible")) {
+		t.Fatalf("Only the tagged field should be encrypted, stored %q", stored)
+	}
+
+	var record encryptionTestRecord
+	if !GetByID(ctx, "plain_test", ID, &record) || record.Secret != "hunter2" {
+		t.Fatalf("Read back %+v", record)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("Field tagged both encrypt and index was accepted")
+			}
+		}()
+		Pack(&encryptionTestEncryptedIndex{Secret: "hunter2"})
+	}()
+}
+
+func TestBucketEncryption(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := newEncryptionTestContext(t, storage, "k1:"+GenerateEncryptionKey())
+
+	first, second := NewID128(), NewID128()
+	for _, ID := range []ID128{first, second} {
+		err := InsertByID(ctx, "encrypted_test", ID, &encryptionTestRecord{Name: "visible"})
+		if err != nil {
+			t.Fatalf("InsertByID: %v", err)
+		}
+	}
+	stored := storedValue(t, storage, "encrypted_test", first)
+	if !IsEncrypted(stored) || bytes.Contains(stored, []byte("visible")) {
+		t.Fatalf("Value of an encrypted bucket is stored as %q", stored)
+	}
+
+	// A value moved to another record doesn't decrypt
+	storage.Update(func(tx Tx) error {
+		return tx.Bucket([]byte("encrypted_test")).Put(second[:], stored)
+	})
+	var record encryptionTestRecord
+	ctx.Database.View(func(tx Tx) error {
+		err := Unpack(tx.Bucket([]byte("encrypted_test")).Get(second[:]), &record)
+		if !IsDecryptionError(err) {
+			t.Fatalf("Moved value was read, error %v", err)
+		}
+		return nil
+	})
+
+	err := InsertByID(ctx, "encrypted_test", NewID128(), &encryptionTestIndexed{Name: "visible"})
+	var indexError EncryptedIndexError
+	if !errors.As(err, &indexError) {
+		t.Fatalf("Indexed type in an encrypted bucket was accepted: %v", err)
+	}
+	err = InsertByID(ctx, "plain_test", NewID128(), &encryptionTestIndexed{Name: "visible"})
+	if err != nil {
+		t.Fatalf("Indexed type in a plain bucket: %v", err)
+	}
+}
+
+func TestReencryptBucket(t *testing.T) {
+	storage := NewMemoryStorage()
+	oldKey, newKey := GenerateEncryptionKey(), GenerateEncryptionKey()
+	ctx := newEncryptionTestContext(t, storage, "old:"+oldKey)
+	RegisterBucketType[encryptionTestRecord]("encrypted_test")
+
+	ID := NewID128()
+	err := InsertByID(ctx, "encrypted_test", ID, &encryptionTestRecord{Name: "visible", Secret: "hunter2"})
+	if err != nil {
+		t.Fatalf("InsertByID: %v", err)
+	}
+
+	ctx = newEncryptionTestContext(t, storage, "new:"+newKey+",old:"+oldKey)
+	rewritten, err := ReencryptBucket(ctx, "encrypted_test")
+	if err != nil || rewritten != 1 {
+		t.Fatalf("ReencryptBucket rewrote %v: %v", rewritten, err)
+	}
+	if keyID, _ := EnvelopeKeyID(storedValue(t, storage, "encrypted_test", ID)); keyID != "new" {
+		t.Fatalf("Record is still encrypted with %v", keyID)
+	}
+
+	ctx = newEncryptionTestContext(t, storage, "new:"+newKey) // Old key dropped
+	var record encryptionTestRecord
+	if !GetByID(ctx, "encrypted_test", ID, &record) || record.Secret != "hunter2" {
+		t.Fatalf("Record can't be read without the old key: %+v", record)
+	}
+}
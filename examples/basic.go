@@ -5,8 +5,8 @@ import (
 	//"github.com/gorilla/mux"
 	ef "github.com/sigmawq/easyframework"
 	"log"
-	"net"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 )
@@ -24,15 +24,16 @@ const (
 )
 
 type User struct {
-	ID            ef.ID128 `id:"1"`
-	Name          string   `id:"2"`
-	Password      string   `id:"3"`
-	PreviousNames []string `id:"4"`
+	ID            ef.ID[ef.User] `id:"1"` // The framework type, sessions take it as it is
+	Name          string         `id:"2" index:"name,unique"`
+	Password      string         `id:"3" tag:"password"` // Hash, see ef.HashPassword
+	PreviousNames []string       `id:"4"`
+	Type          UserType       `id:"5"`
 }
 
 type LoginRequest struct {
 	Username string `description:"login or email" tag:"required"`
-	Password string `description:"at most several attempts per minute!" tag:"required"`
+	Password string `description:"at most several attempts per minute!" tag:"required,password"`
 }
 
 type LoginResponse struct {
@@ -46,77 +47,83 @@ const (
 	ERROR_CONTENT_NOT_FOUND   = "content_not_found"
 )
 
-func Login(ctx *ef.RequestContext, request LoginRequest) (response Session, problem ef.Problem) {
-	tx, _ := ef.WriteTx(efContext)
-	defer tx.Rollback()
+func Login(ctx *ef.RequestContext, request LoginRequest) (response ef.Session, problem ef.AccountLockedProblem) {
+	problem, ok := ef.GuardLogin(ctx, request.Username)
+	if !ok {
+		return
+	}
 
+	tx, _ := ef.ReadTx(efContext)
 	users, _ := ef.GetBucket(tx, BUCKET_USERS)
-	var user User
-	if !ef.IterateFind(users, &user, func(id ef.ID128, value *User) bool {
-		return value.Name == request.Username
-	}) {
+	_, user, found, err := ef.FindByIndex[User](users, "name", request.Username)
+	tx.Rollback()
+	if err != nil || !found {
+		ef.LoginFailed(ctx, request.Username)
 		problem.ErrorID = ERROR_INVALID_CREDENTIALS
 		return
 	}
 
-	if request.Password != user.Password {
+	ok, rehashed, err := ef.VerifyAndRehashPassword(request.Password, &user.Password)
+	if err != nil || !ok {
+		ef.LoginFailed(ctx, request.Username)
 		problem.ErrorID = ERROR_INVALID_CREDENTIALS
 		return
 	}
+	ef.LoginSucceeded(ctx, request.Username)
 
-	ip, _, _ := net.SplitHostPort(ctx.Request.RemoteAddr)
-
-	sessionID := ef.NewID128()
-	response = Session{
-		ID:        sessionID,
-		UserID:    user.ID,
-		ExpiresAt: time.Now().Add(time.Hour * 24).Unix(),
-		IP:        ip,
+	if rehashed {
+		ef.InsertByID(efContext, BUCKET_USERS, user.ID.Raw(), &user)
 	}
 
-	sessions, _ := ef.GetBucket(tx, BUCKET_SESSIONS)
-	ef.Insert(sessions, sessionID, &response)
-
-	tx.Commit()
-
-	http.SetCookie(ctx.ResponseWriter, &http.Cookie{
-		Name:    "session",
-		Value:   sessionID.String(),
-		Expires: time.Unix(response.ExpiresAt, 0),
-	})
+	response, err = ef.StartSession(ctx, user.ID)
+	if err != nil {
+		problem.ErrorID = ef.ERROR_INTERNAL
+		return
+	}
 
 	return
 }
 
 func Logout(ctx *ef.RequestContext) (problem ef.Problem) {
+	err := ef.EndSession(ctx)
+	if err != nil {
+		problem.ErrorID = ef.ERROR_INTERNAL
+	}
 	return
 }
 
 const (
-	BUCKET_USERS    = "Users"
-	BUCKET_SESSIONS = "Sessions"
+	BUCKET_USERS = "Users"
 )
 
 func ListAllBuckets(ctx *ef.RequestContext) (result []interface{}, problem ef.Problem) {
-	tx, _ := efContext.Database.Begin(false)
-
 	{
-		bucket, _ := ef.GetBucket(tx, BUCKET_USERS)
+		bucket, _ := ef.GetBucket(ctx.Tx, BUCKET_USERS)
 
 		things := ef.IterateCollectAll[User](bucket)
 		result = append(result, things)
 	}
 
 	{
-		bucket, _ := ef.GetBucket(tx, BUCKET_SESSIONS)
+		bucket, _ := ef.GetBucket(ctx.Tx, ef.BUCKET_SESSIONS)
 
-		things := ef.IterateCollectAll[Session](bucket)
+		things := ef.IterateCollectAll[ef.Session](bucket)
 		result = append(result, things)
 	}
 
 	return
 }
 
+func ListUsers(ctx *ef.RequestContext, request ef.PageRequest) (response ef.PageResponse[User], problem ef.Problem) {
+	users, _ := ef.GetBucket(ctx.Tx, BUCKET_USERS)
+	response, err := ef.Paginate[User](users, request)
+	if err != nil {
+		problem.ErrorID = ef.ERROR_VALIDATION_FAILED
+		problem.Message = err.Error()
+	}
+	return
+}
+
 var efContext *ef.Context
 
 type GetDocumentationRequest struct {
@@ -193,52 +200,24 @@ func RPC_RestTest(context *ef.RequestContext) (value []interface{}, problem ef.P
 	return
 }
 
-type Session struct {
-	ID          ef.ID128 `id:"1"`
-	UserID      ef.ID128 `id:"2"`
-	ExpiresAt   int64    `id:"3"`
-	AccessCount int64    `id:"4"`
-	IP          string   `id:"5"`
-}
-
-func Authorization(ctx *ef.RequestContext, w http.ResponseWriter, r *http.Request) bool {
-	_session, err := r.Cookie("session")
-	if err != nil {
-		return false
-	}
-
-	var sessionID ef.ID128
-	err = sessionID.FromString(_session.Value)
+func HashPassword(password string) string {
+	hash, err := ef.HashPassword(password)
 	if err != nil {
-		return false
-	}
-
-	var session Session
-	if !ef.GetByID(efContext, BUCKET_SESSIONS, sessionID, &session) {
-		return false
-	}
-
-	ip, _, _ := net.SplitHostPort(ctx.Request.RemoteAddr)
-	if ip != session.IP {
-		return false
+		panic(err)
 	}
+	return hash
+}
 
+func ResolvePermissions(ctx *ef.RequestContext) (principal ef.Principal, err error) {
 	var user User
-	if !ef.GetByID(efContext, BUCKET_USERS, session.UserID, &user) {
-		return false
+	if !ef.GetByID(efContext, BUCKET_USERS, ctx.UserID.Raw(), &user) {
+		return
 	}
-	session.AccessCount += 1
-
-	ef.InsertByID(efContext, BUCKET_SESSIONS, sessionID, &session)
 
-	log.Printf("User: %#v", user)
-	log.Printf("Session: %#v", session)
-
-	return true
-}
-
-type CustomProcedurePermission struct {
-	IsAdminOnly bool
+	if user.Type == USER_TYPE_ADMIN {
+		principal.Roles = append(principal.Roles, "admin")
+	}
+	return
 }
 
 func main() {
@@ -248,9 +227,62 @@ func main() {
 		StdoutLogging:        true,
 		FileLogging:          true,
 		DatabasePath:         "db",
-		Authorization:        Authorization,
 		MaxRequestsPerMinute: 5,
+		Sessions: &ef.SessionParams{
+			Sliding:         true,
+			InsecureCookies: true,
+		},
+		CSRF: &ef.CSRFParams{
+			InsecureCookies: true,
+		},
+		LoginGuard: &ef.LoginGuardParams{
+			AdminProcedures: true,
+		},
+		APIKeys: &ef.APIKeyParams{
+			AdminProcedures: true,
+		},
+		PermissionResolver: ResolvePermissions,
+		RolePermissions: map[string][]string{
+			"admin": {ef.PERMISSION_ALL},
+		},
+		Backups: &ef.BackupParams{
+			Period:         time.Hour,
+			AdminProcedure: true,
+		},
+		Integrity: &ef.IntegrityParams{
+			AdminProcedure: true,
+		},
+	}
+
+	if os.Getenv("EF_MEMORY") != "" { // Run without touching the file system, everything is lost on exit
+		params.Storage = ef.NewMemoryStorage()
+		params.FileLogging = false
+		params.Backups = nil
+	}
+
+	if os.Getenv("EF_KEYS") != "" { // e.g. EF_KEYS=2024-06:<ef.GenerateEncryptionKey()>, prepend a new key to rotate
+		keys, err := ef.KeyRingFromEnv("EF_KEYS")
+		if err != nil {
+			log.Println("Bad EF_KEYS:", err)
+			return
+		}
+		params.Encryption = &ef.EncryptionParams{
+			Keys:    keys,
+			Buckets: []ef.BucketID{BUCKET_USERS, ef.BUCKET_SESSIONS},
+		}
 	}
+
+	ef.RegisterBucketType[User](BUCKET_USERS)
+	ef.RegisterBucketType[ef.Session](ef.BUCKET_SESSIONS)
+
+	if handled, err := ef.RunCommand(params, os.Args[1:]); handled {
+		if err != nil {
+			log.Println("Command failed:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	err := ef.Initialize(efContext, params)
 	if err != nil {
 		log.Println("Error while initializing EF:", err)
@@ -263,7 +295,7 @@ func main() {
 			panic(err)
 		}
 
-		err = ef.NewBucket(efContext, BUCKET_SESSIONS)
+		err = ef.EnsureIndexes[User](efContext, BUCKET_USERS)
 		if err != nil {
 			panic(err)
 		}
@@ -277,15 +309,16 @@ func main() {
 		}
 		{
 			user1 := User{
-				ID:       ef.NewID128(),
+				ID:       ef.NewSortableID[ef.User](),
 				Name:     fmt.Sprintf("User-%v", ef.GenerateSixteenDigitCode()),
-				Password: ef.GenerateSixteenDigitCode(),
+				Password: HashPassword(ef.GenerateSixteenDigitCode()),
+				Type:     USER_TYPE_ADMIN,
 			}
 
 			user2 := User{
-				ID:       ef.NewID128(),
+				ID:       ef.NewSortableID[ef.User](),
 				Name:     fmt.Sprintf("User-%v", ef.GenerateSixteenDigitCode()),
-				Password: ef.GenerateSixteenDigitCode(),
+				Password: HashPassword(ef.GenerateSixteenDigitCode()),
 				PreviousNames: []string{
 					"Previousname1",
 					"Previousname2",
@@ -293,12 +326,12 @@ func main() {
 				},
 			}
 
-			err := ef.Insert(bucket, user1.ID, &user1)
+			err := ef.Insert(bucket, user1.ID.Raw(), &user1)
 			if err != nil {
 				panic(err)
 			}
 
-			err = ef.Insert(bucket, user2.ID, &user2)
+			err = ef.Insert(bucket, user2.ID.Raw(), &user2)
 			if err != nil {
 				panic(err)
 			}
@@ -318,11 +351,19 @@ func main() {
 		Handler: Logout,
 	})
 
+	ef.NewRPC(efContext, ef.NewRPCParams{
+		Name:        "ListUsers",
+		Handler:     ListUsers,
+		Permissions: []string{"users.read"},
+		Transaction: ef.TRANSACTION_READ,
+	})
+
 	ef.NewRPC(efContext, ef.NewRPCParams{
 		Name:                     "ListBuckets",
 		Description:              "Bla bla bla",
 		Handler:                  ListAllBuckets,
 		AuthorizationNotRequired: true,
+		Transaction:              ef.TRANSACTION_READ,
 	})
 
 	ef.NewRPC(efContext, ef.NewRPCParams{
@@ -330,9 +371,7 @@ func main() {
 		Name:        "LogList",
 		Description: "Get list of all logs",
 		Handler:     RPC_GetLogList,
-		UserData: CustomProcedurePermission{
-			IsAdminOnly: true,
-		},
+		Permissions: []string{"logs.read"},
 	})
 
 	ef.NewRPC(efContext, ef.NewRPCParams{
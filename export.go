@@ -0,0 +1,519 @@
+package easyframework
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+/*
+Buckets are exported as JSON Lines, one record per line:
+	{"ID":"<ID128>","Value":{...},"ExpiresAt":"..."}
+Value is the JSON of the Go type registered for the bucket, ExpiresAt is only there for expiring records.
+Types with stored fields that JSON leaves out (`json:"-"`, like APIKey.Hash) would lose them, records of those are
+exported as Packed instead: the record as it is stored, base64. Encrypted fields stay encrypted in it.
+*/
+
+type ExportedRecord struct {
+	ID        string
+	Value     json.RawMessage `json:",omitempty"`
+	Packed    []byte          `json:",omitempty"`
+	ExpiresAt *time.Time      `json:",omitempty"`
+}
+
+type ImportMode int
+
+const (
+	IMPORT_UPSERT  ImportMode = iota // Existing records are overwritten
+	IMPORT_SKIP                      // Existing records are kept
+	IMPORT_REPLACE                   // The bucket is emptied first, then everything is inserted, all in one transaction
+)
+
+type ImportParams struct {
+	Mode      ImportMode
+	BatchSize int                                      // Records per transaction, default is 1000. IMPORT_REPLACE only uses it for Progress.
+	Progress  func(processed int, result ImportResult) // Called after every batch
+}
+
+type ImportResult struct {
+	Inserted int
+	Updated  int
+	Skipped  int
+}
+
+type ExportParams struct {
+	Progress func(exported int) // Called every 1000 records
+}
+
+// BucketType lets export and import work with a bucket without knowing its Go type at compile time
+type BucketType struct {
+	Bucket  BucketID
+	Type    reflect.Type
+	packed  bool // Exported as Packed, JSON would lose fields
+	marshal func(data []byte) ([]byte, error)
+	unpack  func(data []byte) (reflect.Value, error) // Addressable struct value
+	pack    func(value reflect.Value) ([]byte, error)
+	insert  func(tx Tx, ID ID128, record ExportedRecord) error
+	delete  func(bucket Bucket, ID ID128) error
+}
+
+var bucketTypesMutex sync.RWMutex
+var bucketTypes = make(map[BucketID]BucketType)
+
+// RegisterBucketType tells export, import and the integrity check which type the records of the bucket are
+func RegisterBucketType[T any](bucketID BucketID) {
+	typeof := reflect.TypeOf((*T)(nil)).Elem()
+	bucketTypesMutex.Lock()
+	defer bucketTypesMutex.Unlock()
+	bucketTypes[bucketID] = BucketType{
+		Bucket: bucketID,
+		Type:   typeof,
+		packed: hasFieldsHiddenFromJSON(typeof, make(map[reflect.Type]bool)),
+		marshal: func(data []byte) ([]byte, error) {
+			var value T
+			err := Unpack(data, &value)
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(&value)
+		},
+		unpack: func(data []byte) (reflect.Value, error) {
+			var value T
+			err := Unpack(data, &value)
+			return reflect.ValueOf(&value).Elem(), err
+		},
+		pack: func(value reflect.Value) ([]byte, error) {
+			return Pack(value.Addr().Interface().(*T))
+		},
+		insert: func(tx Tx, ID ID128, record ExportedRecord) error {
+			var value T
+			var err error
+			if record.Packed != nil {
+				err = Unpack(record.Packed, &value)
+			} else {
+				err = json.Unmarshal(record.Value, &value)
+			}
+			if err != nil {
+				return err
+			}
+
+			if record.ExpiresAt != nil {
+				return InsertWithExpiry(tx, bucketID, ID, &value, *record.ExpiresAt)
+			}
+			bucket, err := GetBucket(tx, bucketID)
+			if err != nil {
+				return err
+			}
+			return Insert(bucket, ID, &value)
+		},
+		delete: Delete[T],
+	}
+}
+
+// hasFieldsHiddenFromJSON tells if a stored field of the type, or of a type in it, is tagged `json:"-"`
+func hasFieldsHiddenFromJSON(typeof reflect.Type, seen map[reflect.Type]bool) bool {
+	switch typeof.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		return hasFieldsHiddenFromJSON(typeof.Elem(), seen)
+	case reflect.Map:
+		return hasFieldsHiddenFromJSON(typeof.Key(), seen) || hasFieldsHiddenFromJSON(typeof.Elem(), seen)
+	case reflect.Struct:
+		if seen[typeof] {
+			return false
+		}
+		seen[typeof] = true
+
+		for i := 0; i < typeof.NumField(); i += 1 {
+			field := typeof.Field(i)
+			if field.Tag.Get("id") == "" {
+				continue
+			}
+			if JsonFieldName(field) == "-" || hasFieldsHiddenFromJSON(field.Type, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func lookupBucketType(bucketID BucketID) (BucketType, bool) {
+	bucketTypesMutex.RLock()
+	defer bucketTypesMutex.RUnlock()
+	bucketType, ok := bucketTypes[bucketID]
+	return bucketType, ok
+}
+
+// registeredBucketIDs returns the buckets with a registered type, sorted
+func registeredBucketIDs() []BucketID {
+	bucketTypesMutex.RLock()
+	defer bucketTypesMutex.RUnlock()
+	var buckets []BucketID
+	for bucketID := range bucketTypes {
+		buckets = append(buckets, bucketID)
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i] < buckets[j]
+	})
+	return buckets
+}
+
+func GetBucketType(bucketID BucketID) (BucketType, error) {
+	bucketType, ok := lookupBucketType(bucketID)
+	if !ok {
+		return bucketType, fmt.Errorf("No type is registered for bucket %v, see RegisterBucketType", bucketID)
+	}
+	return bucketType, nil
+}
+
+// ExportBucket writes every record of the bucket as a JSON line, expired records are left out
+func ExportBucket(ctx *Context, bucketID BucketID, w io.Writer, params ExportParams) (exported int, err error) {
+	bucketType, err := GetBucketType(bucketID)
+	if err != nil {
+		return 0, err
+	}
+
+	writer := bufio.NewWriter(w)
+	err = ctx.Database.View(func(tx Tx) error {
+		bucket, err := GetBucket(tx, bucketID)
+		if err != nil {
+			return err
+		}
+
+		expiry := NewExpiryChecker(bucket)
+		cursor := bucket.Cursor()
+		for key, data := cursor.First(); key != nil; key, data = cursor.Next() {
+			if data == nil || expiry.IsExpired(key) {
+				continue
+			}
+
+			ID := ID128(key)
+			record := ExportedRecord{ID: ID.String()}
+			if bucketType.packed {
+				record.Packed = data
+			} else {
+				record.Value, err = bucketType.marshal(data)
+				if err != nil {
+					return RecordUnpackError{Bucket: bucketID, ID: ID, Err: err}
+				}
+			}
+			if expiresAt, ok := GetExpiry(bucket, ID); ok {
+				record.ExpiresAt = &expiresAt
+			}
+
+			line, err := json.Marshal(&record)
+			if err != nil {
+				return err
+			}
+			writer.Write(line)
+			err = writer.WriteByte('\n')
+			if err != nil {
+				return err
+			}
+
+			exported += 1
+			if params.Progress != nil && exported%1000 == 0 {
+				params.Progress(exported)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return exported, err
+	}
+
+	if params.Progress != nil {
+		params.Progress(exported)
+	}
+	return exported, writer.Flush()
+}
+
+// ImportBucket reads JSON lines written by ExportBucket. Every batch is its own transaction, a failed import leaves the
+// batches before it applied. IMPORT_REPLACE is one transaction instead, a failed replace leaves the bucket as it was.
+func ImportBucket(ctx *Context, bucketID BucketID, r io.Reader, params ImportParams) (result ImportResult, err error) {
+	bucketType, err := GetBucketType(bucketID)
+	if err != nil {
+		return result, err
+	}
+	if params.BatchSize == 0 {
+		params.BatchSize = 1000
+	}
+
+	err = NewBucket(ctx, bucketID)
+	if err != nil {
+		return result, err
+	}
+
+	reader := importReader{scanner: bufio.NewScanner(r)}
+	reader.scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	if params.Mode == IMPORT_REPLACE {
+		err = ctx.Database.Update(func(tx Tx) error {
+			bucket, err := GetBucket(tx, bucketID)
+			if err != nil {
+				return err
+			}
+			err = clearBucket(bucket, bucketType)
+			if err != nil {
+				return err
+			}
+
+			for !reader.done {
+				records, lines, err := reader.next(params.BatchSize)
+				if err != nil {
+					return err
+				}
+				err = importRecords(tx, bucket, bucketType, records, lines, params.Mode, &result)
+				if err != nil {
+					return err
+				}
+				if params.Progress != nil && len(records) > 0 {
+					params.Progress(result.Inserted+result.Updated+result.Skipped, result)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return ImportResult{}, err
+		}
+		return result, nil
+	}
+
+	for !reader.done {
+		records, lines, err := reader.next(params.BatchSize)
+		if err != nil {
+			return result, err
+		}
+
+		batch := ImportResult{}
+		err = ctx.Database.Update(func(tx Tx) error {
+			bucket, err := GetBucket(tx, bucketID)
+			if err != nil {
+				return err
+			}
+			return importRecords(tx, bucket, bucketType, records, lines, params.Mode, &batch)
+		})
+		if err != nil {
+			return result, err
+		}
+
+		result.Inserted += batch.Inserted
+		result.Updated += batch.Updated
+		result.Skipped += batch.Skipped
+		if params.Progress != nil && len(records) > 0 {
+			params.Progress(result.Inserted+result.Updated+result.Skipped, result)
+		}
+	}
+
+	return result, nil
+}
+
+type importReader struct {
+	scanner *bufio.Scanner
+	line    int
+	done    bool
+}
+
+// next parses up to count records, lines are their line numbers for errors
+func (reader *importReader) next(count int) (records []ExportedRecord, lines []int, err error) {
+	for len(records) < count {
+		if !reader.scanner.Scan() {
+			reader.done = true
+			break
+		}
+		reader.line += 1
+		if len(reader.scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record ExportedRecord
+		err := json.Unmarshal(reader.scanner.Bytes(), &record)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %v: %w", reader.line, err)
+		}
+		records = append(records, record)
+		lines = append(lines, reader.line)
+	}
+	return records, lines, reader.scanner.Err()
+}
+
+func importRecords(tx Tx, bucket Bucket, bucketType BucketType, records []ExportedRecord, lines []int, mode ImportMode, result *ImportResult) error {
+	for i, record := range records {
+		ID, err := ParseID128(record.ID)
+		if err != nil {
+			return fmt.Errorf("line %v: %w", lines[i], err)
+		}
+
+		exists := bucket.Get(ID[:]) != nil && !IsExpired(bucket, ID)
+		if exists && mode == IMPORT_SKIP {
+			result.Skipped += 1
+			continue
+		}
+
+		err = bucketType.insert(tx, ID, record)
+		if err != nil {
+			return fmt.Errorf("line %v: %w", lines[i], err)
+		}
+
+		if exists {
+			result.Updated += 1
+		} else {
+			result.Inserted += 1
+		}
+	}
+	return nil
+}
+
+// clearBucket deletes every record through the registered type, so indexes and expiry are cleaned up as well
+func clearBucket(bucket Bucket, bucketType BucketType) error {
+	var keys []ID128
+	cursor := bucket.Cursor()
+	for key, data := cursor.First(); key != nil; key, data = cursor.Next() {
+		if data != nil {
+			keys = append(keys, ID128(key))
+		}
+	}
+
+	for _, ID := range keys {
+		err := bucketType.delete(bucket, ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ParseImportMode(mode string) (ImportMode, error) {
+	switch mode {
+	case "", "upsert":
+		return IMPORT_UPSERT, nil
+	case "skip":
+		return IMPORT_SKIP, nil
+	case "replace":
+		return IMPORT_REPLACE, nil
+	}
+	return 0, fmt.Errorf("Unknown import mode %v, expected upsert, skip or replace", mode)
+}
+
+// openDatabaseForCommand opens the database of the params for commands, it fails if the server is running
+func openDatabaseForCommand(params InitializeParams) (*Context, error) {
+	if params.DatabasePath == "" {
+		return nil, errors.New("DatabasePath is required")
+	}
+
+	database, err := OpenBoltStorage(params.DatabasePath, 0777, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Database %v can't be opened, stop the server first: %w", params.DatabasePath, err)
+	}
+
+	ctx := &Context{DatabasePath: params.DatabasePath, Database: database}
+	if params.Encryption != nil {
+		err = InitializeEncryption(ctx, *params.Encryption)
+		if err != nil {
+			database.Close()
+			return nil, err
+		}
+	}
+	return ctx, nil
+}
+
+func registeredBuckets() string {
+	return fmt.Sprint(registeredBucketIDs())
+}
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "export",
+		Usage:       "<bucket> [file]",
+		Description: "Write records of the bucket as JSON lines to the file or stdout, the server must be stopped",
+		Run: func(params InitializeParams, args []string) error {
+			if len(args) < 1 || len(args) > 2 {
+				return fmt.Errorf("Usage: export <bucket> [file], registered buckets: %v", registeredBuckets())
+			}
+
+			ctx, err := openDatabaseForCommand(params)
+			if err != nil {
+				return err
+			}
+			defer ctx.Database.Close()
+
+			var w io.Writer = os.Stdout
+			if len(args) == 2 {
+				file, err := os.Create(args[1])
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				w = file
+			}
+
+			exported, err := ExportBucket(ctx, BucketID(args[0]), w, ExportParams{
+				Progress: func(exported int) {
+					fmt.Fprintf(os.Stderr, "Exported %v records\n", exported)
+				},
+			})
+			if err != nil {
+				return err
+			}
+			if len(args) == 2 {
+				log.Printf("Exported %v records of %v to %v", exported, args[0], args[1])
+			}
+			return nil
+		},
+	})
+
+	RegisterCommand(Command{
+		Name:        "import",
+		Usage:       "<bucket> <file> [upsert|skip|replace]",
+		Description: "Insert JSON lines written by export into the bucket, the server must be stopped",
+		Run: func(params InitializeParams, args []string) error {
+			if len(args) < 2 || len(args) > 3 {
+				return fmt.Errorf("Usage: import <bucket> <file> [upsert|skip|replace], registered buckets: %v", registeredBuckets())
+			}
+
+			mode := ""
+			if len(args) == 3 {
+				mode = args[2]
+			}
+			importMode, err := ParseImportMode(mode)
+			if err != nil {
+				return err
+			}
+
+			file, err := os.Open(args[1])
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			ctx, err := openDatabaseForCommand(params)
+			if err != nil {
+				return err
+			}
+			defer ctx.Database.Close()
+
+			result, err := ImportBucket(ctx, BucketID(args[0]), file, ImportParams{
+				Mode: importMode,
+				Progress: func(processed int, result ImportResult) {
+					fmt.Fprintf(os.Stderr, "Processed %v records\n", processed)
+				},
+			})
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(os.Stderr, "Inserted %v, updated %v, skipped %v\n", result.Inserted, result.Updated, result.Skipped)
+			return nil
+		},
+	})
+}
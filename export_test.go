@@ -0,0 +1,138 @@
+package easyframework
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+type exportTestRecord struct {
+	Name string `id:"1" index:"export_test_name,unique"`
+}
+
+type exportTestSecret struct {
+	Name string   `id:"1"`
+	Hash [32]byte `id:"2" json:"-"`
+}
+
+func newExportTestContext(t *testing.T) *Context {
+	t.Helper()
+	RegisterBucketType[exportTestRecord]("export_test")
+	RegisterBucketType[exportTestSecret]("export_test_secrets")
+
+	ctx := new(Context)
+	err := Initialize(ctx, InitializeParams{Storage: NewMemoryStorage()})
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	for _, bucketID := range []BucketID{"export_test", "export_test_secrets"} {
+		err = NewBucket(ctx, bucketID)
+		if err != nil {
+			t.Fatalf("NewBucket: %v", err)
+		}
+	}
+	return ctx
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	ctx := newExportTestContext(t)
+	plain, expiring := NewID128(), NewID128()
+	err := InsertByID(ctx, "export_test", plain, &exportTestRecord{Name: "plain"})
+	if err != nil {
+		t.Fatalf("InsertByID: %v", err)
+	}
+	err = InsertByIDWithTTL(ctx, "export_test", expiring, &exportTestRecord{Name: "expiring"}, time.Hour)
+	if err != nil {
+		t.Fatalf("InsertByIDWithTTL: %v", err)
+	}
+
+	var exported bytes.Buffer
+	count, err := ExportBucket(ctx, "export_test", &exported, ExportParams{})
+	if err != nil || count != 2 {
+		t.Fatalf("ExportBucket exported %v: %v", count, err)
+	}
+
+	target := newExportTestContext(t)
+	result, err := ImportBucket(target, "export_test", bytes.NewReader(exported.Bytes()), ImportParams{})
+	if err != nil || result.Inserted != 2 {
+		t.Fatalf("ImportBucket %+v: %v", result, err)
+	}
+	var record exportTestRecord
+	if !GetByID(target, "export_test", plain, &record) || record.Name != "plain" {
+		t.Fatalf("Imported record is %+v", record)
+	}
+	target.Database.View(func(tx Tx) error {
+		bucket := tx.Bucket([]byte("export_test"))
+		if _, ok := GetExpiry(bucket, expiring); !ok {
+			t.Fatalf("Expiry was lost")
+		}
+		ID, _, found, err := FindByIndex[exportTestRecord](bucket, "export_test_name", "expiring")
+		if err != nil || !found || ID != expiring {
+			t.Fatalf("Index wasn't filled by the import: %v %v", found, err)
+		}
+		return nil
+	})
+
+	result, err = ImportBucket(target, "export_test", bytes.NewReader(exported.Bytes()), ImportParams{Mode: IMPORT_SKIP})
+	if err != nil || result.Skipped != 2 || result.Inserted != 0 {
+		t.Fatalf("IMPORT_SKIP %+v: %v", result, err)
+	}
+}
+
+func TestImportReplaceIsAtomic(t *testing.T) {
+	ctx := newExportTestContext(t)
+	existing := NewID128()
+	err := InsertByID(ctx, "export_test", existing, &exportTestRecord{Name: "existing"})
+	if err != nil {
+		t.Fatalf("InsertByID: %v", err)
+	}
+
+	input := `{"ID":"` + NewID128().String() + `","Value":{"Name":"new"}}` + "\n" + `{"ID":"broken"`
+	_, err = ImportBucket(ctx, "export_test", strings.NewReader(input), ImportParams{Mode: IMPORT_REPLACE, BatchSize: 1})
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("Expected an error on line 2, got %v", err)
+	}
+	var record exportTestRecord
+	if !GetByID(ctx, "export_test", existing, &record) {
+		t.Fatalf("Failed replace removed the existing record")
+	}
+
+	input = `{"ID":"` + NewID128().String() + `","Value":{"Name":"new"}}`
+	result, err := ImportBucket(ctx, "export_test", strings.NewReader(input), ImportParams{Mode: IMPORT_REPLACE})
+	if err != nil || result.Inserted != 1 {
+		t.Fatalf("IMPORT_REPLACE %+v: %v", result, err)
+	}
+	if GetByID(ctx, "export_test", existing, &record) {
+		t.Fatalf("Replace kept the existing record")
+	}
+}
+
+func TestExportKeepsFieldsHiddenFromJSON(t *testing.T) {
+	ctx := newExportTestContext(t)
+	ID := NewID128()
+	secret := exportTestSecret{Name: "secret", Hash: [32]byte{1, 2, 3}}
+	err := InsertByID(ctx, "export_test_secrets", ID, &secret)
+	if err != nil {
+		t.Fatalf("InsertByID: %v", err)
+	}
+
+	var exported bytes.Buffer
+	_, err = ExportBucket(ctx, "export_test_secrets", &exported, ExportParams{})
+	if err != nil {
+		t.Fatalf("ExportBucket: %v", err)
+	}
+	if !strings.Contains(exported.String(), `"Packed"`) {
+		t.Fatalf("Record with a hidden field wasn't exported packed: %v", exported.String())
+	}
+
+	target := newExportTestContext(t)
+	_, err = ImportBucket(target, "export_test_secrets", &exported, ImportParams{})
+	if err != nil {
+		t.Fatalf("ImportBucket: %v", err)
+	}
+	var imported exportTestSecret
+	if !GetByID(target, "export_test_secrets", ID, &imported) || imported != secret {
+		t.Fatalf("Imported %+v, expected %+v", imported, secret)
+	}
+}
@@ -25,7 +25,17 @@ type ArrayIndex uint32
 func Pack[T any](target *T) ([]byte, error) {
 	var buffer Buffer
 
-	err := _Pack(&buffer, reflect.TypeOf(target).Elem(), reflect.ValueOf(target).Elem(), -1)
+	targetType := reflect.TypeOf(target).Elem()
+	targetValue := reflect.ValueOf(target).Elem()
+	if fields := encryptedFields(targetType); len(fields) > 0 {
+		var err error
+		targetValue, err = encryptFields(targetType, targetValue, fields)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := _Pack(&buffer, targetType, targetValue, -1)
 
 	return buffer.Buffer[:buffer.Index], err
 }
@@ -260,10 +270,19 @@ func _Pack(buffer *Buffer, targetType reflect.Type, targetValue reflect.Value, f
 }
 
 func Unpack[T any](data []byte, target *T) error {
+	if reflect.TypeOf(target).Elem().Kind() == reflect.Struct && IsEncrypted(data) { // Packed structs never start like an envelope
+		keyID, _ := EnvelopeKeyID(data)
+		return DecryptionError{KeyID: keyID}
+	}
+
 	buffer := Buffer{
 		Buffer: data,
 	}
-	return _Unpack(&buffer, reflect.TypeOf(target).Elem(), reflect.ValueOf(target).Elem())
+	err := _Unpack(&buffer, reflect.TypeOf(target).Elem(), reflect.ValueOf(target).Elem())
+	if err != nil {
+		return err
+	}
+	return decryptFields(reflect.ValueOf(target).Elem(), encryptedFields(reflect.TypeOf(target).Elem()))
 }
 
 type UnpackError struct {
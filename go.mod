@@ -2,9 +2,10 @@ module github.com/sigmawq/easyframework
 
 go 1.23.0
 
-require github.com/boltdb/bolt v1.3.1
-
 require (
-	github.com/gorilla/mux v1.8.1 // indirect
-	golang.org/x/sys v0.29.0 // indirect
+	github.com/boltdb/bolt v1.3.1
+	github.com/gorilla/mux v1.8.1
+	golang.org/x/crypto v0.32.0
 )
+
+require golang.org/x/sys v0.29.0 // indirect
@@ -0,0 +1,243 @@
+package easyframework
+
+import (
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+/*
+Sortable IDs are laid out like ULID:
+	bytes 0-5   unix milliseconds, big endian
+	bytes 6-15  random, incremented instead of regenerated within the same millisecond
+Byte order is creation order, so the database keeps records of sortable IDs sorted by creation time.
+They stay ID128, String and FromString work the same. Don't use them where the ID is a secret, 48 bits of it are a timestamp.
+*/
+
+var sortableIDMutex sync.Mutex
+var lastSortableID ID128
+
+// NewSortableID128 returns an ID greater than every ID it returned before in this process
+func NewSortableID128() ID128 {
+	sortableIDMutex.Lock()
+	defer sortableIDMutex.Unlock()
+
+	now := uint64(time.Now().UnixMilli())
+	last := sortableIDTimestamp(lastSortableID)
+
+	var id ID128
+	if now > last {
+		putSortableIDTimestamp(&id, now)
+		rand.Read(id[6:])
+	} else { // Same millisecond or the clock went back, continue from the last ID
+		id = lastSortableID
+		if !incrementSortableIDRandom(&id) { // 80 bits overflowed, borrow the next millisecond
+			putSortableIDTimestamp(&id, last+1)
+			rand.Read(id[6:])
+		}
+	}
+
+	lastSortableID = id
+	return id
+}
+
+func sortableIDTimestamp(id ID128) uint64 {
+	var timestamp [8]byte
+	copy(timestamp[2:], id[:6])
+	return binary.BigEndian.Uint64(timestamp[:])
+}
+
+func putSortableIDTimestamp(id *ID128, milliseconds uint64) {
+	var timestamp [8]byte
+	binary.BigEndian.PutUint64(timestamp[:], milliseconds)
+	copy(id[:6], timestamp[2:])
+}
+
+func incrementSortableIDRandom(id *ID128) bool {
+	for i := len(id) - 1; i >= 6; i -= 1 {
+		id[i] += 1
+		if id[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Time returns when a sortable ID was created, meaningless for random IDs
+func (id ID128) Time() time.Time {
+	return time.UnixMilli(int64(sortableIDTimestamp(id)))
+}
+
+// SortableID128AtTime is the smallest sortable ID of the moment, use it as a bound to scan records created in a time range
+func SortableID128AtTime(t time.Time) ID128 {
+	var id ID128
+	putSortableIDTimestamp(&id, uint64(t.UnixMilli()))
+	return id
+}
+
+/*
+Text formats of ID128:
+	String  our own base16, "a"-"j" and "1"-"6" with the low nibble uppercased, what JSON uses
+	Hex     standard lowercase hex, 32 characters, "0x" in front for ParseID128
+	UUID    RFC 4122 layout, 8-4-4-4-12 lowercase hex
+	Base32  Crockford base32, 26 characters like ULID
+ParseID128 accepts all of them.
+*/
+
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+type InvalidID128Error struct {
+	Value string
+}
+
+func (v InvalidID128Error) Error() string {
+	return fmt.Sprintf("ID128: %q is not in any of the known formats", v.Value)
+}
+
+func (id ID128) Hex() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id ID128) UUID() string {
+	var str [36]byte
+	hex.Encode(str[0:8], id[0:4])
+	str[8] = '-'
+	hex.Encode(str[9:13], id[4:6])
+	str[13] = '-'
+	hex.Encode(str[14:18], id[6:8])
+	str[18] = '-'
+	hex.Encode(str[19:23], id[8:10])
+	str[23] = '-'
+	hex.Encode(str[24:36], id[10:16])
+	return string(str[:])
+}
+
+// Base32 is Crockford base32, 130 bits with the top two always zero
+func (id ID128) Base32() string {
+	var str [26]byte
+	value := new(big.Int).SetBytes(id[:])
+	mask := big.NewInt(31)
+	digit := new(big.Int)
+	for i := len(str) - 1; i >= 0; i -= 1 {
+		digit.And(value, mask)
+		str[i] = crockfordAlphabet[digit.Int64()]
+		value.Rsh(value, 5)
+	}
+	return string(str[:])
+}
+
+func parseCrockford(str string) (ID128, bool) {
+	var id ID128
+	if len(str) != 26 {
+		return id, false
+	}
+
+	value := new(big.Int)
+	for i := 0; i < len(str); i += 1 {
+		c := unicode.ToUpper(rune(str[i]))
+		switch c {
+		case 'O':
+			c = '0'
+		case 'I', 'L':
+			c = '1'
+		}
+		digit := strings.IndexRune(crockfordAlphabet, c)
+		if digit < 0 {
+			return id, false
+		}
+		value.Lsh(value, 5)
+		value.Or(value, big.NewInt(int64(digit)))
+	}
+	if value.BitLen() > 128 {
+		return id, false
+	}
+
+	value.FillBytes(id[:])
+	return id, true
+}
+
+/*
+ParseID128 accepts String, UUID, Base32 and Hex with a "0x" prefix.
+Every format has its own length, nothing is guessed: 32 characters are always String. Hex needs the prefix, without it
+hex that only has "a"-"f" and "1"-"6" would be a valid String of a different ID.
+*/
+func ParseID128(str string) (ID128, error) {
+	var id ID128
+	switch len(str) {
+	case 36:
+		if str[8] != '-' || str[13] != '-' || str[18] != '-' || str[23] != '-' {
+			return id, InvalidID128Error{Value: str}
+		}
+		_, err := hex.Decode(id[:], []byte(str[0:8]+str[9:13]+str[14:18]+str[19:23]+str[24:36]))
+		if err != nil {
+			return id, InvalidID128Error{Value: str}
+		}
+		return id, nil
+	case 26:
+		id, ok := parseCrockford(str)
+		if !ok {
+			return id, InvalidID128Error{Value: str}
+		}
+		return id, nil
+	case 32:
+		if id.FromString(str) != nil {
+			return id, InvalidID128Error{Value: str}
+		}
+		return id, nil
+	case 34:
+		if !strings.HasPrefix(str, "0x") {
+			return id, InvalidID128Error{Value: str}
+		}
+		_, err := hex.Decode(id[:], []byte(str[2:]))
+		if err != nil {
+			return id, InvalidID128Error{Value: str}
+		}
+		return id, nil
+	}
+
+	return id, InvalidID128Error{Value: str}
+}
+
+// MarshalText uses String, same as JSON
+func (id ID128) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+func (id *ID128) UnmarshalText(text []byte) error {
+	parsed, err := ParseID128(string(text))
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
+
+// Value stores the ID as a UUID string, it fits both uuid and text columns
+func (id ID128) Value() (driver.Value, error) {
+	return id.UUID(), nil
+}
+
+// Scan accepts 16 raw bytes or any text format, NULL is the zero ID
+func (id *ID128) Scan(src interface{}) error {
+	switch value := src.(type) {
+	case nil:
+		*id = ID128{}
+		return nil
+	case []byte:
+		if len(value) == len(id) {
+			copy(id[:], value)
+			return nil
+		}
+		return id.UnmarshalText(value)
+	case string:
+		return id.UnmarshalText([]byte(value))
+	}
+	return fmt.Errorf("ID128: can't scan %T", src)
+}
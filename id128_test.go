@@ -0,0 +1,85 @@
+package easyframework
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseID128Formats(t *testing.T) {
+	for i := 0; i < 100; i += 1 {
+		ID := NewID128()
+		for _, text := range []string{ID.String(), "0x" + ID.Hex(), ID.UUID(), ID.Base32(), strings.ToLower(ID.Base32())} {
+			parsed, err := ParseID128(text)
+			if err != nil || parsed != ID {
+				t.Fatalf("%q parsed as %v, expected %v: %v", text, parsed, ID, err)
+			}
+		}
+	}
+}
+
+func TestParseID128DoesNotGuess(t *testing.T) {
+	var ID ID128
+	for i := range ID {
+		ID[i] = 0x12 // Hex "1212..." only has characters String uses too
+	}
+	var asString ID128
+	if asString.FromString(ID.Hex()) != nil {
+		t.Fatalf("Test hex is not a valid String")
+	}
+	parsed, err := ParseID128(ID.Hex())
+	if err != nil || parsed != asString {
+		t.Fatalf("32 characters were not read as String: %v %v", parsed, err)
+	}
+	_, err = ParseID128(NewID128().Hex()[:31] + "0") // "0" is not in String
+	if err == nil {
+		t.Fatalf("Hex without the prefix was parsed")
+	}
+	parsed, err = ParseID128("0x" + ID.Hex())
+	if err != nil || parsed != ID {
+		t.Fatalf("Prefixed hex parsed as %v: %v", parsed, err)
+	}
+
+	for _, text := range []string{"", "0x1234", strings.Repeat("0", 32), "1x" + ID.Hex(), strings.Repeat("z", 26), "12345678-1234-1234-1234+123456789012"} {
+		if _, err := ParseID128(text); err == nil {
+			t.Fatalf("%q was parsed", text)
+		}
+	}
+}
+
+func TestTypedIDJSON(t *testing.T) {
+	sessionID := NewID[Session]()
+	data, err := json.Marshal(struct{ ID ID[Session] }{sessionID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct{ ID ID[Session] }
+	err = json.Unmarshal(data, &decoded)
+	if err != nil || decoded.ID != sessionID {
+		t.Fatalf("%s decoded as %v: %v", data, decoded.ID, err)
+	}
+
+	err = json.Unmarshal([]byte(`{"ID":"`+sessionID.Raw().UUID()+`"}`), &decoded)
+	if err != nil || decoded.ID != sessionID {
+		t.Fatalf("UUID decoded as %v: %v", decoded.ID, err)
+	}
+}
+
+func TestSortableID128Order(t *testing.T) {
+	before := SortableID128AtTime(time.Now().Add(-time.Millisecond))
+	previous := NewSortableID128()
+	for i := 0; i < 1000; i += 1 {
+		ID := NewSortableID128()
+		if string(ID[:]) <= string(previous[:]) {
+			t.Fatalf("%x is not after %x", ID, previous)
+		}
+		previous = ID
+	}
+	if string(before[:]) >= string(previous[:]) {
+		t.Fatalf("SortableID128AtTime of the past is not before new IDs")
+	}
+	if time.Since(previous.Time()) > time.Second {
+		t.Fatalf("Time of a new ID is %v", previous.Time())
+	}
+}
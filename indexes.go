@@ -0,0 +1,509 @@
+package easyframework
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Indexes live in nested buckets of the bucket they index, named "\x00ef_index:<index name>".
+Entry key is the encoded value followed by the 16 bytes of the record ID, entry value is empty.
+Encoding keeps the order of values and is prefix free, so both exact lookups and range scans are prefix/seek based.
+*/
+
+const INDEX_BUCKET_PREFIX = "\x00ef_index:"
+
+type IndexDefinition struct {
+	Name   string
+	Unique bool
+	Key    func(value reflect.Value) (key interface{}, indexed bool) // value is the record struct
+}
+
+type UniqueIndexViolationError struct {
+	Index      string
+	ExistingID ID128
+}
+
+func (v UniqueIndexViolationError) Error() string {
+	return fmt.Sprintf("Unique index %v violated, value is already used by %v", v.Index, v.ExistingID)
+}
+
+type IndexNotFoundError struct {
+	Index string
+}
+
+func (v IndexNotFoundError) Error() string {
+	return fmt.Sprintf("Index %v not found", v.Index)
+}
+
+var indexesMutex sync.RWMutex
+var registeredIndexes map[reflect.Type][]IndexDefinition
+var taggedIndexesParsed map[reflect.Type]bool
+
+// RegisterIndex adds an index to every bucket that stores T. key returns the indexed value, indexed = false leaves the record out of the index.
+func RegisterIndex[T any](name string, unique bool, key func(value *T) (interface{}, bool)) {
+	typeof := reflect.TypeOf((*T)(nil)).Elem()
+	addIndex(typeof, IndexDefinition{
+		Name:   name,
+		Unique: unique,
+		Key: func(value reflect.Value) (interface{}, bool) {
+			return key(value.Addr().Interface().(*T))
+		},
+	})
+}
+
+func addIndex(typeof reflect.Type, definition IndexDefinition) {
+	GetIndexes(typeof) // Tags first, so a registered index with the same name is caught
+
+	indexesMutex.Lock()
+	defer indexesMutex.Unlock()
+
+	for _, existing := range registeredIndexes[typeof] {
+		if existing.Name == definition.Name {
+			panic(fmt.Sprintf("Index %v is already defined for %v", definition.Name, typeof.Name()))
+		}
+	}
+	registeredIndexes[typeof] = append(registeredIndexes[typeof], definition)
+}
+
+// GetIndexes returns the indexes of T, both from `index:"name,unique"` tags and from RegisterIndex
+func GetIndexes(typeof reflect.Type) []IndexDefinition {
+	indexesMutex.RLock()
+	parsed := taggedIndexesParsed[typeof]
+	indexes := registeredIndexes[typeof]
+	indexesMutex.RUnlock()
+	if parsed {
+		return indexes
+	}
+
+	indexesMutex.Lock()
+	defer indexesMutex.Unlock()
+
+	if registeredIndexes == nil {
+		registeredIndexes = make(map[reflect.Type][]IndexDefinition)
+		taggedIndexesParsed = make(map[reflect.Type]bool)
+	}
+	if taggedIndexesParsed[typeof] {
+		return registeredIndexes[typeof]
+	}
+	taggedIndexesParsed[typeof] = true
+
+	if typeof.Kind() == reflect.Struct {
+		for i := 0; i < typeof.NumField(); i += 1 {
+			field := typeof.Field(i)
+			tag, ok := field.Tag.Lookup("index")
+			if !ok {
+				continue
+			}
+
+			parts := strings.Split(tag, ",")
+			name := parts[0]
+			if name == "" {
+				name = field.Name
+			}
+			_, unique := Search(parts[1:], func(part string) bool {
+				return part == "unique"
+			})
+
+			fieldIndex := i
+			registeredIndexes[typeof] = append(registeredIndexes[typeof], IndexDefinition{
+				Name:   name,
+				Unique: unique,
+				Key: func(value reflect.Value) (interface{}, bool) {
+					fieldValue := value.Field(fieldIndex)
+					if fieldValue.IsZero() { // Empty values are not indexed, so many records can leave a unique field empty
+						return nil, false
+					}
+					return fieldValue.Interface(), true
+				},
+			})
+		}
+	}
+
+	return registeredIndexes[typeof]
+}
+
+func HasIndexes[T any]() bool {
+	return len(GetIndexes(reflect.TypeOf((*T)(nil)).Elem())) > 0
+}
+
+func getIndex(typeof reflect.Type, name string) (IndexDefinition, error) {
+	for _, index := range GetIndexes(typeof) {
+		if index.Name == name {
+			return index, nil
+		}
+	}
+
+	return IndexDefinition{}, IndexNotFoundError{Index: name}
+}
+
+// EncodeIndexValue turns a value into bytes that sort the same way the values do
+func EncodeIndexValue(value interface{}) ([]byte, error) {
+	if t, ok := value.(time.Time); ok {
+		value = t.UnixNano()
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String:
+		return encodeIndexBytes([]byte(v.String())), nil
+	case reflect.Bool:
+		if v.Bool() {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var result [8]byte
+		binary.BigEndian.PutUint64(result[:], uint64(v.Int())^(1<<63))
+		return result[:], nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var result [8]byte
+		binary.BigEndian.PutUint64(result[:], v.Uint())
+		return result[:], nil
+	case reflect.Float32, reflect.Float64:
+		bits := math.Float64bits(v.Float())
+		if bits&(1<<63) != 0 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+		var result [8]byte
+		binary.BigEndian.PutUint64(result[:], bits)
+		return result[:], nil
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 { // ID128 and friends, fixed size so no terminator needed
+			result := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(result), v)
+			return result, nil
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return encodeIndexBytes(v.Bytes()), nil
+		}
+	}
+
+	return nil, fmt.Errorf("Type %v can't be indexed", v.Type())
+}
+
+// encodeIndexBytes escapes 0x00 as 0x00 0xff and terminates with 0x00 0x01, keeping order and making values prefix free
+func encodeIndexBytes(data []byte) []byte {
+	result := make([]byte, 0, len(data)+2)
+	for _, b := range data {
+		result = append(result, b)
+		if b == 0 {
+			result = append(result, 0xff)
+		}
+	}
+	return append(result, 0, 1)
+}
+
+func indexEntries(indexes []IndexDefinition, ID ID128, value reflect.Value) ([][]byte, error) {
+	entries := make([][]byte, len(indexes))
+	for i, index := range indexes {
+		key, indexed := index.Key(value)
+		if !indexed {
+			continue
+		}
+
+		encoded, err := EncodeIndexValue(key)
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = append(encoded, ID[:]...)
+	}
+
+	return entries, nil
+}
+
+func indexBucket(bucket Bucket, index IndexDefinition) Bucket {
+	return bucket.Bucket([]byte(INDEX_BUCKET_PREFIX + index.Name))
+}
+
+// removeIndexEntriesOf drops the index entries of a record whose type is unknown, every entry ends with the ID of its record
+func removeIndexEntriesOf(bucket Bucket, ID ID128) error {
+	for _, name := range nestedBuckets(bucket) {
+		if !bytes.HasPrefix(name, []byte(INDEX_BUCKET_PREFIX)) {
+			continue
+		}
+
+		entries := bucket.Bucket(name)
+		var keys [][]byte
+		cursor := entries.Cursor()
+		for key, _ := cursor.First(); key != nil; key, _ = cursor.Next() {
+			if bytes.HasSuffix(key, ID[:]) {
+				keys = append(keys, append([]byte(nil), key...))
+			}
+		}
+		for _, key := range keys {
+			err := entries.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ensureIndexBuckets creates missing index buckets and fills them from the records that are already there
+func ensureIndexBuckets(bucket Bucket, typeof reflect.Type, indexes []IndexDefinition) error {
+	if len(indexes) > 0 && isEncryptedBucket(bucket) {
+		return EncryptedIndexError{Bucket: string(bucket.Name()), Index: indexes[0].Name}
+	}
+
+	for _, index := range indexes {
+		if indexBucket(bucket, index) != nil {
+			continue
+		}
+
+		newIndexBucket, err := bucket.CreateBucket([]byte(INDEX_BUCKET_PREFIX + index.Name))
+		if err != nil {
+			return err
+		}
+
+		cursor := bucket.Cursor()
+		for key, data := cursor.First(); key != nil; key, data = cursor.Next() {
+			if data == nil { // Nested bucket
+				continue
+			}
+
+			value := reflect.New(typeof)
+			err := _Unpack(&Buffer{Buffer: data}, typeof, value.Elem())
+			if err != nil {
+				return err
+			}
+			err = decryptFields(value.Elem(), encryptedFields(typeof))
+			if err != nil {
+				return err
+			}
+
+			entries, err := indexEntries([]IndexDefinition{index}, ID128(key), value.Elem())
+			if err != nil {
+				return err
+			}
+			if entries[0] == nil {
+				continue
+			}
+			if index.Unique {
+				existingID, taken := findIndexEntry(newIndexBucket, entries[0][:len(entries[0])-16])
+				if taken {
+					return UniqueIndexViolationError{Index: index.Name, ExistingID: existingID}
+				}
+			}
+			err = newIndexBucket.Put(entries[0], []byte{})
+			if err != nil {
+				return err
+			}
+		}
+		log.Printf("Built index %v", index.Name)
+	}
+
+	return nil
+}
+
+func findIndexEntry(indexBucket Bucket, encoded []byte) (ID128, bool) {
+	key, _ := indexBucket.Cursor().Seek(encoded)
+	if key == nil || !bytes.HasPrefix(key, encoded) {
+		return ID128{}, false
+	}
+	return ID128(key[len(encoded):]), true
+}
+
+// updateIndexes moves index entries of the record from the old value to the new one. Either of them can be nil.
+func updateIndexes[T any](bucket Bucket, ID ID128, oldValue, newValue *T) error {
+	typeof := reflect.TypeOf((*T)(nil)).Elem()
+	indexes := GetIndexes(typeof)
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	err := ensureIndexBuckets(bucket, typeof, indexes)
+	if err != nil {
+		return err
+	}
+
+	oldEntries := make([][]byte, len(indexes))
+	if oldValue != nil {
+		oldEntries, err = indexEntries(indexes, ID, reflect.ValueOf(oldValue).Elem())
+		if err != nil {
+			return err
+		}
+	}
+	newEntries := make([][]byte, len(indexes))
+	if newValue != nil {
+		newEntries, err = indexEntries(indexes, ID, reflect.ValueOf(newValue).Elem())
+		if err != nil {
+			return err
+		}
+	}
+
+	// Every unique index is checked before anything changes, a violation leaves all indexes as they were
+	var expired []ID128
+	for i, index := range indexes {
+		if !index.Unique || newEntries[i] == nil || bytes.Equal(oldEntries[i], newEntries[i]) {
+			continue
+		}
+
+		existingID, taken := findIndexEntry(indexBucket(bucket, index), newEntries[i][:len(newEntries[i])-16])
+		if !taken || existingID == ID {
+			continue
+		}
+		if !IsExpired(bucket, existingID) {
+			return UniqueIndexViolationError{Index: index.Name, ExistingID: existingID}
+		}
+		expired = append(expired, existingID) // Not swept yet, it doesn't hold the value anymore
+	}
+
+	for _, expiredID := range expired {
+		if bucket.Get(expiredID[:]) == nil { // Held the value in more than one index
+			continue
+		}
+		err := Delete[T](bucket, expiredID)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, index := range indexes {
+		if bytes.Equal(oldEntries[i], newEntries[i]) {
+			continue
+		}
+
+		entries := indexBucket(bucket, index)
+		if oldEntries[i] != nil {
+			err := entries.Delete(oldEntries[i])
+			if err != nil {
+				return err
+			}
+		}
+		if newEntries[i] != nil {
+			err := entries.Put(newEntries[i], []byte{})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// EnsureIndexes builds the indexes of T that don't exist in the bucket yet, lookups don't see records inserted before the index was built
+func EnsureIndexes[T any](ctx *Context, bucketID BucketID) error {
+	typeof := reflect.TypeOf((*T)(nil)).Elem()
+	indexes := GetIndexes(typeof)
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	return ctx.Database.Update(func(tx Tx) error {
+		bucket, err := GetBucket(tx, bucketID)
+		if err != nil {
+			return err
+		}
+
+		return ensureIndexBuckets(bucket, typeof, indexes)
+	})
+}
+
+// FindByIndex returns the first record with the value, meant for unique indexes
+func FindByIndex[T any](bucket Bucket, indexName string, value interface{}) (ID ID128, result T, found bool, err error) {
+	err = ScanIndex(bucket, indexName, value, func(recordID ID128, record *T) bool {
+		ID = recordID
+		result = *record
+		found = true
+		return false
+	})
+	return
+}
+
+// FindAllByIndex returns every record with the value
+func FindAllByIndex[T any](bucket Bucket, indexName string, value interface{}) (result []T, err error) {
+	err = ScanIndex(bucket, indexName, value, func(recordID ID128, record *T) bool {
+		result = append(result, *record)
+		return true
+	})
+	return
+}
+
+// ScanIndex calls the procedure for records with the value, until it returns false
+func ScanIndex[T any](bucket Bucket, indexName string, value interface{}, procedure func(ID ID128, value *T) bool) error {
+	encoded, err := EncodeIndexValue(value)
+	if err != nil {
+		return err
+	}
+
+	return scanIndex(bucket, indexName, encoded, func(key []byte) bool {
+		return bytes.HasPrefix(key, encoded)
+	}, procedure)
+}
+
+// ScanIndexRange goes over records with from <= value < to in the order of the index. nil from or to means no bound.
+func ScanIndexRange[T any](bucket Bucket, indexName string, from, to interface{}, procedure func(ID ID128, value *T) bool) error {
+	var start, end []byte
+	var err error
+	if from != nil {
+		start, err = EncodeIndexValue(from)
+		if err != nil {
+			return err
+		}
+	}
+	if to != nil {
+		end, err = EncodeIndexValue(to)
+		if err != nil {
+			return err
+		}
+	}
+
+	return scanIndex(bucket, indexName, start, func(key []byte) bool {
+		return end == nil || bytes.Compare(key[:len(key)-16], end) < 0
+	}, procedure)
+}
+
+func scanIndex[T any](bucket Bucket, indexName string, start []byte, inRange func(key []byte) bool, procedure func(ID ID128, value *T) bool) error {
+	index, err := getIndex(reflect.TypeOf((*T)(nil)).Elem(), indexName)
+	if err != nil {
+		return err
+	}
+
+	entries := indexBucket(bucket, index)
+	if entries == nil { // Nothing was inserted since the index was declared
+		return nil
+	}
+
+	expiry := NewExpiryChecker(bucket)
+	cursor := entries.Cursor()
+	var key []byte
+	if start == nil {
+		key, _ = cursor.First()
+	} else {
+		key, _ = cursor.Seek(start)
+	}
+	for ; key != nil && inRange(key); key, _ = cursor.Next() {
+		ID := ID128(key[len(key)-16:])
+		if expiry.IsExpired(ID[:]) {
+			continue
+		}
+		data := bucket.Get(ID[:])
+		if data == nil {
+			return fmt.Errorf("Index %v points to a missing record %v", indexName, ID)
+		}
+
+		var value T
+		err := Unpack(data, &value)
+		if err != nil {
+			return err
+		}
+
+		if !procedure(ID, &value) {
+			break
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,250 @@
+package easyframework
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type indexTestUser struct {
+	Name string `id:"1" index:"index_test_name,unique"`
+	Age  int32  `id:"2" index:"index_test_age"`
+}
+
+type indexTestUnindexed struct {
+	Name string `id:"1"`
+	Age  int32  `id:"2"`
+}
+
+type indexTestRegistered struct {
+	Email string `id:"1"`
+}
+
+func newIndexTestContext(t *testing.T) *Context {
+	t.Helper()
+	ctx := new(Context)
+	err := Initialize(ctx, InitializeParams{Storage: NewMemoryStorage()})
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	err = NewBucket(ctx, "index_test")
+	if err != nil {
+		t.Fatalf("NewBucket: %v", err)
+	}
+	return ctx
+}
+
+func findIndexTestUser(t *testing.T, ctx *Context, name string) (ID128, bool) {
+	t.Helper()
+	var ID ID128
+	var found bool
+	ctx.Database.View(func(tx Tx) error {
+		var err error
+		ID, _, found, err = FindByIndex[indexTestUser](tx.Bucket([]byte("index_test")), "index_test_name", name)
+		if err != nil {
+			t.Fatalf("FindByIndex: %v", err)
+		}
+		return nil
+	})
+	return ID, found
+}
+
+func TestUniqueIndex(t *testing.T) {
+	ctx := newIndexTestContext(t)
+	alice, bob := NewID128(), NewID128()
+	err := InsertByID(ctx, "index_test", alice, &indexTestUser{Name: "alice", Age: 30})
+	if err != nil {
+		t.Fatalf("InsertByID: %v", err)
+	}
+	err = InsertByID(ctx, "index_test", bob, &indexTestUser{Name: "bob", Age: 30})
+	if err != nil {
+		t.Fatalf("InsertByID: %v", err)
+	}
+
+	if ID, found := findIndexTestUser(t, ctx, "alice"); !found || ID != alice {
+		t.Fatalf("FindByIndex alice: %v %v", ID, found)
+	}
+
+	err = InsertByID(ctx, "index_test", bob, &indexTestUser{Name: "alice", Age: 40})
+	var violation UniqueIndexViolationError
+	if !errors.As(err, &violation) || violation.ExistingID != alice {
+		t.Fatalf("Expected a unique violation by alice, got %v", err)
+	}
+	var stored indexTestUser
+	if !GetByID(ctx, "index_test", bob, &stored) || stored.Name != "bob" || stored.Age != 30 {
+		t.Fatalf("Failed insert changed the record: %+v", stored)
+	}
+	ctx.Database.View(func(tx Tx) error { // The age index wasn't touched either
+		ages, err := FindAllByIndex[indexTestUser](tx.Bucket([]byte("index_test")), "index_test_age", int32(40))
+		if err != nil || len(ages) != 0 {
+			t.Fatalf("Failed insert left index entries: %v %v", ages, err)
+		}
+		return nil
+	})
+
+	err = InsertByID(ctx, "index_test", bob, &indexTestUser{Name: "robert", Age: 30})
+	if err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if _, found := findIndexTestUser(t, ctx, "bob"); found {
+		t.Fatalf("Old value is still in the index")
+	}
+	if ID, found := findIndexTestUser(t, ctx, "robert"); !found || ID != bob {
+		t.Fatalf("New value is not in the index")
+	}
+
+	err = ctx.Database.Update(func(tx Tx) error {
+		return Delete[indexTestUser](tx.Bucket([]byte("index_test")), alice)
+	})
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, found := findIndexTestUser(t, ctx, "alice"); found {
+		t.Fatalf("Deleted record is still in the index")
+	}
+	err = InsertByID(ctx, "index_test", NewID128(), &indexTestUser{Name: "alice"})
+	if err != nil {
+		t.Fatalf("Value of a deleted record can't be reused: %v", err)
+	}
+
+	for i := 0; i < 2; i += 1 { // Empty values are left out, they don't collide
+		err = InsertByID(ctx, "index_test", NewID128(), &indexTestUser{})
+		if err != nil {
+			t.Fatalf("Empty unique value: %v", err)
+		}
+	}
+}
+
+func TestUniqueIndexIgnoresExpiredRecords(t *testing.T) {
+	ctx := newIndexTestContext(t)
+	expiring := NewID128()
+	err := ctx.Database.Update(func(tx Tx) error {
+		return InsertWithExpiry(tx, "index_test", expiring, &indexTestUser{Name: "alice"}, time.Now().Add(-time.Second))
+	})
+	if err != nil {
+		t.Fatalf("InsertWithExpiry: %v", err)
+	}
+
+	if _, found := findIndexTestUser(t, ctx, "alice"); found {
+		t.Fatalf("Expired record was found")
+	}
+	err = InsertByID(ctx, "index_test", NewID128(), &indexTestUser{Name: "alice"})
+	if err != nil {
+		t.Fatalf("Expired record still holds the value: %v", err)
+	}
+	var stored indexTestUser
+	if GetByID(ctx, "index_test", expiring, &stored) {
+		t.Fatalf("Expired record that held the value wasn't removed")
+	}
+}
+
+func TestScanIndexRange(t *testing.T) {
+	ctx := newIndexTestContext(t)
+	for _, age := range []int32{40, -5, 7, 30, 0, 7, 1000} {
+		err := InsertByID(ctx, "index_test", NewID128(), &indexTestUser{Name: NewID128().String(), Age: age})
+		if err != nil {
+			t.Fatalf("InsertByID: %v", err)
+		}
+	}
+
+	ctx.Database.View(func(tx Tx) error {
+		var ages []int32
+		err := ScanIndexRange(tx.Bucket([]byte("index_test")), "index_test_age", int32(-10), int32(40), func(ID ID128, user *indexTestUser) bool {
+			ages = append(ages, user.Age)
+			return true
+		})
+		if err != nil {
+			t.Fatalf("ScanIndexRange: %v", err)
+		}
+		if len(ages) != 4 || ages[0] != -5 || ages[1] != 7 || ages[2] != 7 || ages[3] != 30 { // 0 is not indexed
+			t.Fatalf("Expected -5 7 7 30, got %v", ages)
+		}
+
+		ages = nil
+		ScanIndexRange(tx.Bucket([]byte("index_test")), "index_test_age", int32(40), nil, func(ID ID128, user *indexTestUser) bool {
+			ages = append(ages, user.Age)
+			return true
+		})
+		if len(ages) != 2 || ages[0] != 40 || ages[1] != 1000 {
+			t.Fatalf("Expected 40 1000, got %v", ages)
+		}
+
+		_, _, _, err = FindByIndex[indexTestUser](tx.Bucket([]byte("index_test")), "missing", "x")
+		var notFound IndexNotFoundError
+		if !errors.As(err, &notFound) {
+			t.Fatalf("Expected IndexNotFoundError, got %v", err)
+		}
+		return nil
+	})
+}
+
+func TestEnsureIndexesBuildsFromExistingRecords(t *testing.T) {
+	ctx := newIndexTestContext(t)
+	alice := NewID128()
+	err := InsertByID(ctx, "index_test", alice, &indexTestUnindexed{Name: "alice", Age: 30})
+	if err != nil {
+		t.Fatalf("InsertByID: %v", err)
+	}
+
+	if _, found := findIndexTestUser(t, ctx, "alice"); found {
+		t.Fatalf("Record inserted without indexes was found")
+	}
+	err = EnsureIndexes[indexTestUser](ctx, "index_test")
+	if err != nil {
+		t.Fatalf("EnsureIndexes: %v", err)
+	}
+	if ID, found := findIndexTestUser(t, ctx, "alice"); !found || ID != alice {
+		t.Fatalf("EnsureIndexes didn't index the existing record")
+	}
+
+	err = InsertByID(ctx, "index_test", NewID128(), &indexTestUnindexed{Name: "alice"})
+	if err != nil {
+		t.Fatalf("InsertByID: %v", err)
+	}
+	err = ctx.Database.Update(func(tx Tx) error { // Rebuild from scratch over records that break the unique index
+		bucket := tx.Bucket([]byte("index_test"))
+		err := bucket.DeleteBucket([]byte(INDEX_BUCKET_PREFIX + "index_test_name"))
+		if err != nil {
+			return err
+		}
+		return ensureIndexBuckets(bucket, reflect.TypeOf(indexTestUser{}), GetIndexes(reflect.TypeOf(indexTestUser{})))
+	})
+	var violation UniqueIndexViolationError
+	if !errors.As(err, &violation) {
+		t.Fatalf("Rebuild over duplicate values: expected a unique violation, got %v", err)
+	}
+}
+
+func TestRegisterIndex(t *testing.T) {
+	RegisterIndex(
+		"index_test_domain", false, func(value *indexTestRegistered) (interface{}, bool) {
+			at := strings.LastIndex(value.Email, "@")
+			return value.Email[at+1:], at >= 0
+		})
+
+	ctx := newIndexTestContext(t)
+	for _, email := range []string{"a@example.com", "b@example.com", "c@other.com", "no domain"} {
+		err := InsertByID(ctx, "index_test", NewID128(), &indexTestRegistered{Email: email})
+		if err != nil {
+			t.Fatalf("InsertByID: %v", err)
+		}
+	}
+	ctx.Database.View(func(tx Tx) error {
+		found, err := FindAllByIndex[indexTestRegistered](tx.Bucket([]byte("index_test")), "index_test_domain", "example.com")
+		if err != nil || len(found) != 2 {
+			t.Fatalf("Expected 2 records of example.com, got %v %v", found, err)
+		}
+		return nil
+	})
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Second index with the same name was accepted")
+		}
+	}()
+	RegisterIndex("index_test_domain", false, func(value *indexTestRegistered) (interface{}, bool) {
+		return value.Email, true
+	})
+}
@@ -0,0 +1,528 @@
+package easyframework
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+CheckIntegrity walks the buckets registered with RegisterBucketType and looks for:
+	unpack_failed           record bytes that don't decode into the registered type, or a key that is not an ID128
+	decryption_failed       a record encrypted with a key that is missing or different, see IsDecryptionError
+	orphaned_reference      an ID field tagged `ref:"<bucket>"` that points to a missing or expired record
+	dangling_index_entry    an index entry whose record is gone or has a different value now
+	missing_index_entry     a record that is not in one of the indexes of its type
+
+With a repair action, broken records (unpack failures and orphans) are quarantined or dropped, dangling index entries
+are dropped and missing ones added. Records that fail to decrypt are never touched, the right key brings them back. Quarantined records are kept as they were in BUCKET_QUARANTINE, in a nested bucket
+named like the bucket they came from.
+*/
+
+const PERMISSION_INTEGRITY_ADMIN = "integrity.admin"
+
+const BUCKET_QUARANTINE = "ef_quarantine"
+
+type IntegrityIssueKind string
+
+const (
+	INTEGRITY_UNPACK_FAILED        IntegrityIssueKind = "unpack_failed"
+	INTEGRITY_DECRYPTION_FAILED                       = "decryption_failed"
+	INTEGRITY_ORPHANED_REFERENCE                      = "orphaned_reference"
+	INTEGRITY_DANGLING_INDEX_ENTRY                    = "dangling_index_entry"
+	INTEGRITY_MISSING_INDEX_ENTRY                     = "missing_index_entry"
+)
+
+type RepairAction int8
+
+const (
+	REPAIR_NONE       RepairAction = iota // Only report
+	REPAIR_QUARANTINE                     // Move broken records to BUCKET_QUARANTINE
+	REPAIR_DROP                           // Delete broken records
+)
+
+func (action RepairAction) String() string {
+	switch action {
+	case REPAIR_QUARANTINE:
+		return "quarantine"
+	case REPAIR_DROP:
+		return "drop"
+	}
+	return "none"
+}
+
+func ParseRepairAction(action string) (RepairAction, error) {
+	switch action {
+	case "", "none":
+		return REPAIR_NONE, nil
+	case "quarantine":
+		return REPAIR_QUARANTINE, nil
+	case "drop":
+		return REPAIR_DROP, nil
+	}
+	return 0, fmt.Errorf("Unknown repair action %v, expected none, quarantine or drop", action)
+}
+
+type IntegrityParams struct {
+	Period         time.Duration // How often the check runs in the background, report only. Zero means only on demand.
+	AdminProcedure bool          // Register Integrity.Check, it requires PERMISSION_INTEGRITY_ADMIN
+}
+
+type CheckIntegrityParams struct {
+	Buckets []BucketID // Default is every registered bucket
+	Repair  RepairAction
+}
+
+type IntegrityIssue struct {
+	Bucket   BucketID
+	ID       ID128
+	Kind     IntegrityIssueKind
+	Index    string `description:"index of index entry issues"`
+	Detail   string
+	Repaired bool
+}
+
+type IntegrityReport struct {
+	Buckets []BucketID
+	Records int
+	Issues  []IntegrityIssue
+}
+
+func (report IntegrityReport) Repaired() int {
+	repaired := 0
+	for _, issue := range report.Issues {
+		if issue.Repaired {
+			repaired += 1
+		}
+	}
+	return repaired
+}
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "check",
+		Usage:       "[none|quarantine|drop] [bucket...]",
+		Description: "Check registered buckets for broken records and indexes and optionally repair them, the server must be stopped",
+		Run: func(params InitializeParams, args []string) error {
+			checkParams := CheckIntegrityParams{}
+			if len(args) > 0 {
+				repair, err := ParseRepairAction(args[0])
+				if err != nil {
+					return err
+				}
+				checkParams.Repair = repair
+				for _, bucket := range args[1:] {
+					checkParams.Buckets = append(checkParams.Buckets, BucketID(bucket))
+				}
+			}
+
+			ctx, err := openDatabaseForCommand(params)
+			if err != nil {
+				return err
+			}
+			defer ctx.Database.Close()
+
+			report, err := CheckIntegrity(ctx, checkParams)
+			if err != nil {
+				return err
+			}
+
+			for _, issue := range report.Issues {
+				fmt.Fprintln(os.Stderr, issue.String())
+			}
+			fmt.Fprintf(os.Stderr, "Checked %v records in %v buckets, %v issues, %v repaired\n", report.Records, len(report.Buckets), len(report.Issues), report.Repaired())
+			return nil
+		},
+	})
+}
+
+func (issue IntegrityIssue) String() string {
+	str := fmt.Sprintf("%v %v %v", issue.Bucket, issue.ID, issue.Kind)
+	if issue.Index != "" {
+		str += " index " + issue.Index
+	}
+	if issue.Detail != "" {
+		str += ": " + issue.Detail
+	}
+	if issue.Repaired {
+		str += " (repaired)"
+	}
+	return str
+}
+
+func InitializeIntegrity(ctx *Context, params IntegrityParams) {
+	ctx.Integrity = &params
+
+	if params.Period != 0 {
+		go IntegrityCheckRoutine(ctx)
+	}
+
+	if params.AdminProcedure {
+		NewRPC(ctx, NewRPCParams{
+			Name:        "Integrity.Check",
+			Handler:     RPC_CheckIntegrity,
+			Category:    "Integrity",
+			Description: "Check registered buckets for broken records and indexes, optionally repair them",
+			Permissions: []string{PERMISSION_INTEGRITY_ADMIN},
+		})
+	}
+}
+
+// CheckIntegrity checks every bucket in its own transaction, a write transaction if there is something to repair
+func CheckIntegrity(ctx *Context, params CheckIntegrityParams) (report IntegrityReport, err error) {
+	buckets := params.Buckets
+	if len(buckets) == 0 {
+		buckets = registeredBucketIDs()
+	}
+
+	for _, bucketID := range buckets {
+		bucketType, err := GetBucketType(bucketID)
+		if err != nil {
+			return report, err
+		}
+
+		check := func(tx Tx) error {
+			bucket := tx.Bucket([]byte(bucketID))
+			if bucket == nil { // Nothing was stored yet
+				return nil
+			}
+			return checkBucket(tx, bucket, bucketType, params.Repair, &report)
+		}
+		if params.Repair == REPAIR_NONE {
+			err = ctx.Database.View(check)
+		} else {
+			err = ctx.Database.Update(check)
+		}
+		if err != nil {
+			return report, fmt.Errorf("Integrity check of %v FAILED: %w", bucketID, err)
+		}
+		report.Buckets = append(report.Buckets, bucketID)
+	}
+
+	return report, nil
+}
+
+type brokenRecord struct {
+	key     []byte
+	decoded bool
+	issue   int // Index in report.Issues
+}
+
+type missingIndexEntry struct {
+	ID    ID128
+	index IndexDefinition
+	entry []byte
+	issue int
+}
+
+func checkBucket(tx Tx, bucket Bucket, bucketType BucketType, repair RepairAction, report *IntegrityReport) error {
+	bucketID := bucketType.Bucket
+	indexes := GetIndexes(bucketType.Type)
+	references := referenceFields(bucketType.Type)
+
+	var broken []brokenRecord
+	var missing []missingIndexEntry
+	addIssue := func(issue IntegrityIssue) int {
+		issue.Bucket = bucketID
+		report.Issues = append(report.Issues, issue)
+		return len(report.Issues) - 1
+	}
+
+	cursor := bucket.Cursor()
+	for key, data := cursor.First(); key != nil; key, data = cursor.Next() {
+		if data == nil { // Nested bucket
+			continue
+		}
+		report.Records += 1
+
+		if len(key) != len(ID128{}) {
+			issue := addIssue(IntegrityIssue{Kind: INTEGRITY_UNPACK_FAILED, Detail: "Key " + hex.EncodeToString(key) + " is not an ID128"})
+			broken = append(broken, brokenRecord{key: append([]byte(nil), key...), issue: issue})
+			continue
+		}
+		ID := ID128(key)
+
+		value, err := bucketType.unpack(data)
+		if err != nil && IsDecryptionError(err) {
+			addIssue(IntegrityIssue{ID: ID, Kind: INTEGRITY_DECRYPTION_FAILED, Detail: err.Error()})
+			continue
+		}
+		if err != nil {
+			issue := addIssue(IntegrityIssue{ID: ID, Kind: INTEGRITY_UNPACK_FAILED, Detail: err.Error()})
+			broken = append(broken, brokenRecord{key: ID[:], issue: issue})
+			continue
+		}
+
+		orphaned := false
+		for _, reference := range references {
+			target := ID128{}
+			reflect.Copy(reflect.ValueOf(target[:]), value.Field(reference.field))
+			if target == (ID128{}) {
+				continue
+			}
+			if !recordExists(tx, reference.bucket, target) {
+				issue := addIssue(IntegrityIssue{ID: ID, Kind: INTEGRITY_ORPHANED_REFERENCE, Detail: fmt.Sprintf("%v refers to %v, it is not in %v", reference.name, target, reference.bucket)})
+				if !orphaned {
+					broken = append(broken, brokenRecord{key: ID[:], decoded: true, issue: issue})
+					orphaned = true
+				}
+			}
+		}
+
+		entries, err := indexEntries(indexes, ID, value)
+		if err != nil {
+			return err
+		}
+		for i, index := range indexes {
+			if entries[i] == nil {
+				continue
+			}
+			entriesBucket := indexBucket(bucket, index)
+			if entriesBucket == nil || !hasKey(entriesBucket, entries[i]) {
+				issue := addIssue(IntegrityIssue{ID: ID, Kind: INTEGRITY_MISSING_INDEX_ENTRY, Index: index.Name})
+				missing = append(missing, missingIndexEntry{ID: ID, index: index, entry: entries[i], issue: issue})
+			}
+		}
+	}
+
+	if repair != REPAIR_NONE {
+		removed := make(map[ID128]bool)
+		for _, record := range broken {
+			err := removeBrokenRecord(tx, bucket, bucketType, record, repair)
+			if err != nil {
+				return err
+			}
+			report.Issues[record.issue].Repaired = true
+			if len(record.key) == len(ID128{}) {
+				removed[ID128(record.key)] = true
+			}
+		}
+
+		for _, entry := range missing {
+			if removed[entry.ID] {
+				report.Issues[entry.issue].Repaired = true
+				continue
+			}
+
+			entriesBucket, err := bucket.CreateBucketIfNotExists([]byte(INDEX_BUCKET_PREFIX + entry.index.Name))
+			if err != nil {
+				return err
+			}
+			if entry.index.Unique {
+				existingID, taken := findIndexEntry(entriesBucket, entry.entry[:len(entry.entry)-16])
+				if taken && existingID != entry.ID {
+					report.Issues[entry.issue].Detail = fmt.Sprintf("Unique value is already used by %v", existingID)
+					continue
+				}
+			}
+			err = entriesBucket.Put(entry.entry, []byte{})
+			if err != nil {
+				return err
+			}
+			report.Issues[entry.issue].Repaired = true
+		}
+	}
+
+	// Index entries are checked after broken records are removed, so entries left by those are caught too
+	for _, index := range indexes {
+		entriesBucket := indexBucket(bucket, index)
+		if entriesBucket == nil {
+			continue
+		}
+
+		var dangling [][]byte
+		var issues []int
+		entriesCursor := entriesBucket.Cursor()
+		for entry, _ := entriesCursor.First(); entry != nil; entry, _ = entriesCursor.Next() {
+			detail := danglingIndexEntry(bucket, bucketType, index, entry)
+			if detail == "" {
+				continue
+			}
+
+			var ID ID128
+			if len(entry) >= len(ID) {
+				ID = ID128(entry[len(entry)-len(ID):])
+			}
+			issues = append(issues, addIssue(IntegrityIssue{ID: ID, Kind: INTEGRITY_DANGLING_INDEX_ENTRY, Index: index.Name, Detail: detail}))
+			dangling = append(dangling, append([]byte(nil), entry...))
+		}
+
+		if repair == REPAIR_NONE {
+			continue
+		}
+		for i, entry := range dangling {
+			err := entriesBucket.Delete(entry)
+			if err != nil {
+				return err
+			}
+			report.Issues[issues[i]].Repaired = true
+		}
+	}
+
+	return nil
+}
+
+// danglingIndexEntry explains what is wrong with the entry, empty if it is fine or the record can't be decoded
+func danglingIndexEntry(bucket Bucket, bucketType BucketType, index IndexDefinition, entry []byte) string {
+	if len(entry) < len(ID128{}) {
+		return "Entry is too short"
+	}
+	ID := ID128(entry[len(entry)-len(ID128{}):])
+
+	data := bucket.Get(ID[:])
+	if data == nil {
+		return "Record doesn't exist"
+	}
+	value, err := bucketType.unpack(data)
+	if err != nil { // Already reported as unpack_failed
+		return ""
+	}
+
+	entries, err := indexEntries([]IndexDefinition{index}, ID, value)
+	if err != nil || !bytes.Equal(entries[0], entry) {
+		return "Record has a different value"
+	}
+	return ""
+}
+
+func removeBrokenRecord(tx Tx, bucket Bucket, bucketType BucketType, record brokenRecord, repair RepairAction) error {
+	if repair == REPAIR_QUARANTINE {
+		quarantine, err := tx.CreateBucketIfNotExists([]byte(BUCKET_QUARANTINE))
+		if err != nil {
+			return err
+		}
+		quarantine, err = quarantine.CreateBucketIfNotExists([]byte(bucketType.Bucket))
+		if err != nil {
+			return err
+		}
+		err = quarantine.Put(record.key, bucket.Get(record.key))
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(record.key) != len(ID128{}) {
+		return bucket.Delete(record.key)
+	}
+
+	ID := ID128(record.key)
+	if record.decoded { // Removes its index entries and tells subscribers
+		return bucketType.delete(bucket, ID)
+	}
+
+	err := clearExpiry(bucket, ID)
+	if err != nil {
+		return err
+	}
+	err = removeVersion(bucket, ID)
+	if err != nil {
+		return err
+	}
+	return bucket.Delete(ID[:])
+}
+
+func recordExists(tx Tx, bucketID BucketID, ID ID128) bool {
+	bucket := tx.Bucket([]byte(bucketID))
+	return bucket != nil && bucket.Get(ID[:]) != nil && !IsExpired(bucket, ID)
+}
+
+func hasKey(bucket Bucket, key []byte) bool {
+	found, _ := bucket.Cursor().Seek(key)
+	return found != nil && bytes.Equal(found, key)
+}
+
+type referenceField struct {
+	name   string
+	field  int
+	bucket BucketID
+}
+
+// referenceFields returns fields tagged `ref:"<bucket>"`, they must be ID128 or ID[T]
+func referenceFields(typeof reflect.Type) []referenceField {
+	var references []referenceField
+	if typeof.Kind() != reflect.Struct {
+		return nil
+	}
+
+	idType := reflect.TypeOf(ID128{})
+	for i := 0; i < typeof.NumField(); i += 1 {
+		field := typeof.Field(i)
+		bucket, ok := field.Tag.Lookup("ref")
+		if !ok {
+			continue
+		}
+		if !field.Type.ConvertibleTo(idType) || field.Type.Kind() != reflect.Array {
+			log.Printf("Field %v of %v is tagged ref but isn't an ID128, ignoring it", field.Name, typeof.Name())
+			continue
+		}
+		references = append(references, referenceField{name: field.Name, field: i, bucket: BucketID(bucket)})
+	}
+	return references
+}
+
+func IntegrityCheckRoutine(ctx *Context) {
+	for {
+		time.Sleep(ctx.Integrity.Period)
+
+		start := time.Now()
+		report, err := CheckIntegrity(ctx, CheckIntegrityParams{})
+		if err != nil {
+			log.Printf("%v", err)
+			continue
+		}
+		if len(report.Issues) == 0 {
+			log.Printf("Integrity check of %v records done in %v, no issues", report.Records, time.Since(start))
+			continue
+		}
+
+		kinds := make(map[IntegrityIssueKind]int)
+		for _, issue := range report.Issues {
+			kinds[issue.Kind] += 1
+		}
+		var summary []string
+		for kind, count := range kinds {
+			summary = append(summary, fmt.Sprintf("%v %v", count, kind))
+		}
+		sort.Strings(summary)
+		log.Printf("Integrity check FOUND ISSUES: %v, run the check command or Integrity.Check to see and repair them", strings.Join(summary, ", "))
+	}
+}
+
+type CheckIntegrityRequest struct {
+	Buckets []string `description:"default is every registered bucket"`
+	Repair  string   `description:"none, quarantine or drop"`
+}
+
+func RPC_CheckIntegrity(requestContext *RequestContext, request CheckIntegrityRequest) (report IntegrityReport, problem Problem) {
+	repair, err := ParseRepairAction(request.Repair)
+	if err != nil {
+		problem.ErrorID = ERROR_VALIDATION_FAILED
+		problem.Message = err.Error()
+		return
+	}
+
+	params := CheckIntegrityParams{Repair: repair}
+	for _, bucket := range request.Buckets {
+		_, err := GetBucketType(BucketID(bucket))
+		if err != nil {
+			problem.ErrorID = ERROR_VALIDATION_FAILED
+			problem.Message = err.Error()
+			return
+		}
+		params.Buckets = append(params.Buckets, BucketID(bucket))
+	}
+
+	report, err = CheckIntegrity(requestContext.Context, params)
+	if err != nil {
+		log.Printf("%v", err)
+		problem.ErrorID = ERROR_INTERNAL
+		problem.Message = err.Error()
+	}
+	return
+}
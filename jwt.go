@@ -0,0 +1,242 @@
+package easyframework
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+type JWTParams struct {
+	Keys         map[string][]byte // Key ID -> HS256 secret, keep retired keys here until the tokens signed with them expire
+	CurrentKeyID string            // Key used to sign new tokens
+	Issuer       string
+	Audience     string
+	Lifetime     time.Duration    // Default is 1 hour
+	ClockSkew    time.Duration    // Tolerance for exp/nbf/iat, default is 1 minute
+	Claims       interface{}      // Zero value of the custom claims struct, tokens are decoded into a new one of this type
+	Now          func() time.Time // Default is time.Now, replace it to get a fixed clock
+}
+
+// JWTClaims are the registered claims, custom claims live next to them in the same json object
+type JWTClaims struct {
+	Issuer    string `json:"iss,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	ID        string `json:"jti,omitempty"`
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+type JWTError struct {
+	Reason string
+}
+
+func (v JWTError) Error() string {
+	return fmt.Sprintf("Invalid token: %v", v.Reason)
+}
+
+func InitializeJWT(ctx *Context, params JWTParams) error {
+	if params.Lifetime == 0 {
+		params.Lifetime = time.Hour
+	}
+	if params.ClockSkew == 0 {
+		params.ClockSkew = time.Minute
+	}
+	if params.Now == nil {
+		params.Now = time.Now
+	}
+	if _, ok := params.Keys[params.CurrentKeyID]; !ok {
+		return JWTError{Reason: fmt.Sprintf("current key %v is not in Keys", params.CurrentKeyID)}
+	}
+
+	ctx.JWT = &params
+
+	if ctx.Authorization != nil {
+		ctx.Authorization = AnyAuthorization(JWTAuthorization, ctx.Authorization)
+	} else {
+		ctx.Authorization = JWTAuthorization
+	}
+
+	return nil
+}
+
+// IssueJWT signs a token for the subject with the current key. customClaims can be nil, otherwise it should marshal into a json object.
+func IssueJWT(ctx *Context, subject string, customClaims interface{}) (string, error) {
+	params := ctx.JWT
+	now := params.Now()
+
+	payload := make(map[string]interface{})
+	if customClaims != nil {
+		data, err := json.Marshal(customClaims)
+		if err != nil {
+			return "", err
+		}
+		err = json.Unmarshal(data, &payload)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	claims := JWTClaims{
+		Issuer:    params.Issuer,
+		Subject:   subject,
+		Audience:  params.Audience,
+		ExpiresAt: now.Add(params.Lifetime).Unix(),
+		IssuedAt:  now.Unix(),
+		ID:        NewID128().String(),
+	}
+	{ // Registered claims win over custom ones with the same name
+		data, _ := json.Marshal(claims)
+		json.Unmarshal(data, &payload)
+	}
+
+	header, err := json.Marshal(jwtHeader{
+		Algorithm: "HS256",
+		Type:      "JWT",
+		KeyID:     params.CurrentKeyID,
+	})
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	signature := signJWT(params.Keys[params.CurrentKeyID], signingInput)
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func signJWT(key []byte, signingInput string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
+
+// VerifyJWT checks the signature and the registered claims, customClaims (a pointer, can be nil) receives the payload
+func VerifyJWT(ctx *Context, token string, customClaims interface{}) (JWTClaims, error) {
+	params := ctx.JWT
+	var claims JWTClaims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, JWTError{Reason: "wrong number of parts"}
+	}
+
+	headerData, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return claims, JWTError{Reason: "bad header encoding"}
+	}
+	var header jwtHeader
+	err = json.Unmarshal(headerData, &header)
+	if err != nil {
+		return claims, JWTError{Reason: "bad header"}
+	}
+	if header.Algorithm != "HS256" { // Never let the token choose the algorithm
+		return claims, JWTError{Reason: fmt.Sprintf("unsupported algorithm %v", header.Algorithm)}
+	}
+	key, ok := params.Keys[header.KeyID]
+	if !ok {
+		return claims, JWTError{Reason: fmt.Sprintf("unknown key %v", header.KeyID)}
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, JWTError{Reason: "bad signature encoding"}
+	}
+	if !hmac.Equal(signature, signJWT(key, parts[0]+"."+parts[1])) {
+		return claims, JWTError{Reason: "bad signature"}
+	}
+
+	body, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, JWTError{Reason: "bad payload encoding"}
+	}
+	err = json.Unmarshal(body, &claims)
+	if err != nil {
+		return claims, JWTError{Reason: "bad payload"}
+	}
+
+	now := params.Now()
+	skew := int64(params.ClockSkew / time.Second)
+	if claims.ExpiresAt == 0 || now.Unix() > claims.ExpiresAt+skew {
+		return claims, JWTError{Reason: "expired"}
+	}
+	if claims.NotBefore != 0 && now.Unix() < claims.NotBefore-skew {
+		return claims, JWTError{Reason: "not valid yet"}
+	}
+	if claims.IssuedAt != 0 && now.Unix() < claims.IssuedAt-skew {
+		return claims, JWTError{Reason: "issued in the future"}
+	}
+	if params.Issuer != "" && claims.Issuer != params.Issuer {
+		return claims, JWTError{Reason: "wrong issuer"}
+	}
+	if params.Audience != "" && claims.Audience != params.Audience {
+		return claims, JWTError{Reason: "wrong audience"}
+	}
+
+	if customClaims != nil {
+		err = json.Unmarshal(body, customClaims)
+		if err != nil {
+			return claims, JWTError{Reason: fmt.Sprintf("bad custom claims: %v", err)}
+		}
+	}
+
+	return claims, nil
+}
+
+// JWTAuthorization accepts requests with a valid Authorization: Bearer token.
+// The subject becomes UserID if it is an ID128, custom claims are available through GetClaims.
+func JWTAuthorization(requestContext *RequestContext, w http.ResponseWriter, r *http.Request) bool {
+	ctx := requestContext.Context
+
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return false
+	}
+	token := strings.TrimSpace(authorization[len("Bearer "):])
+	if strings.Count(token, ".") != 2 { // Not a JWT, some other Authorization may know what it is
+		return false
+	}
+
+	var customClaims interface{}
+	if ctx.JWT.Claims != nil {
+		customClaims = reflect.New(reflect.TypeOf(ctx.JWT.Claims)).Interface()
+	}
+
+	claims, err := VerifyJWT(ctx, token, customClaims)
+	if err != nil {
+		return false
+	}
+
+	var userID ID[User]
+	if (*ID128)(&userID).FromString(claims.Subject) == nil {
+		requestContext.UserID = userID
+	}
+	requestContext.JWTClaims = &claims
+	requestContext.Claims = customClaims
+	requestContext.Credential = CREDENTIAL_HEADER
+
+	return true
+}
+
+// GetClaims returns the custom claims of the request, T should be the type of JWTParams.Claims
+func GetClaims[T any](requestContext *RequestContext) (*T, bool) {
+	claims, ok := requestContext.Claims.(*T)
+	return claims, ok
+}
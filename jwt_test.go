@@ -0,0 +1,223 @@
+package easyframework
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+type testClaims struct {
+	Role  string `json:"role"`
+	Level int    `json:"level"`
+}
+
+func newTestJWTContext(t *testing.T, now *time.Time) *Context {
+	t.Helper()
+	ctx := new(Context)
+	err := InitializeJWT(ctx, JWTParams{
+		Keys:         map[string][]byte{"k1": []byte("first secret"), "k2": []byte("second secret")},
+		CurrentKeyID: "k1",
+		Issuer:       "issuer",
+		Audience:     "audience",
+		Lifetime:     time.Hour,
+		ClockSkew:    time.Minute,
+		Claims:       testClaims{},
+		Now:          func() time.Time { return *now },
+	})
+	if err != nil {
+		t.Fatalf("InitializeJWT: %v", err)
+	}
+	return ctx
+}
+
+// signTestJWT builds a token by hand, so tests can set any header and claims
+func signTestJWT(t *testing.T, header jwtHeader, payload interface{}, key []byte) string {
+	t.Helper()
+	headerData, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payloadData, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(headerData) + "." + base64.RawURLEncoding.EncodeToString(payloadData)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signJWT(key, signingInput))
+}
+
+func expectJWTError(t *testing.T, err error, reason string) {
+	t.Helper()
+	var jwtError JWTError
+	if !errors.As(err, &jwtError) {
+		t.Fatalf("Expected JWTError %q, got %v", reason, err)
+	}
+	if jwtError.Reason != reason {
+		t.Fatalf("Expected reason %q, got %q", reason, jwtError.Reason)
+	}
+}
+
+func TestJWTIssueAndVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ctx := newTestJWTContext(t, &now)
+
+	token, err := IssueJWT(ctx, "user-1", nil)
+	if err != nil {
+		t.Fatalf("IssueJWT: %v", err)
+	}
+
+	claims, err := VerifyJWT(ctx, token, nil)
+	if err != nil {
+		t.Fatalf("VerifyJWT: %v", err)
+	}
+	if claims.Subject != "user-1" || claims.Issuer != "issuer" || claims.Audience != "audience" {
+		t.Fatalf("Unexpected claims %+v", claims)
+	}
+	if claims.IssuedAt != now.Unix() || claims.ExpiresAt != now.Add(time.Hour).Unix() {
+		t.Fatalf("Unexpected times iat %v exp %v", claims.IssuedAt, claims.ExpiresAt)
+	}
+	if claims.ID == "" {
+		t.Fatalf("Token has no jti")
+	}
+
+	forged := signTestJWT(t, jwtHeader{Algorithm: "HS256", Type: "JWT", KeyID: "k1"}, claims, []byte("some other secret"))
+	_, err = VerifyJWT(ctx, forged, nil)
+	expectJWTError(t, err, "bad signature")
+}
+
+func TestJWTExpiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ctx := newTestJWTContext(t, &now)
+
+	token, err := IssueJWT(ctx, "user-1", nil)
+	if err != nil {
+		t.Fatalf("IssueJWT: %v", err)
+	}
+
+	now = now.Add(time.Hour + 30*time.Second) // Past exp, within the skew
+	_, err = VerifyJWT(ctx, token, nil)
+	if err != nil {
+		t.Fatalf("Token within the clock skew was rejected: %v", err)
+	}
+
+	now = now.Add(time.Minute) // Past exp and the skew
+	_, err = VerifyJWT(ctx, token, nil)
+	expectJWTError(t, err, "expired")
+}
+
+func TestJWTNotBeforeAndIssuedAt(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ctx := newTestJWTContext(t, &now)
+	key := ctx.JWT.Keys["k1"]
+	header := jwtHeader{Algorithm: "HS256", Type: "JWT", KeyID: "k1"}
+
+	claims := JWTClaims{Issuer: "issuer", Audience: "audience", ExpiresAt: now.Add(time.Hour).Unix()}
+
+	claims.NotBefore = now.Add(30 * time.Second).Unix()
+	_, err := VerifyJWT(ctx, signTestJWT(t, header, claims, key), nil)
+	if err != nil {
+		t.Fatalf("nbf within the clock skew was rejected: %v", err)
+	}
+	claims.NotBefore = now.Add(2 * time.Minute).Unix()
+	_, err = VerifyJWT(ctx, signTestJWT(t, header, claims, key), nil)
+	expectJWTError(t, err, "not valid yet")
+
+	claims.NotBefore = 0
+	claims.IssuedAt = now.Add(30 * time.Second).Unix()
+	_, err = VerifyJWT(ctx, signTestJWT(t, header, claims, key), nil)
+	if err != nil {
+		t.Fatalf("iat within the clock skew was rejected: %v", err)
+	}
+	claims.IssuedAt = now.Add(2 * time.Minute).Unix()
+	_, err = VerifyJWT(ctx, signTestJWT(t, header, claims, key), nil)
+	expectJWTError(t, err, "issued in the future")
+
+	claims.IssuedAt = 0
+	claims.ExpiresAt = 0
+	_, err = VerifyJWT(ctx, signTestJWT(t, header, claims, key), nil)
+	expectJWTError(t, err, "expired")
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ctx := newTestJWTContext(t, &now)
+
+	oldToken, err := IssueJWT(ctx, "user-1", nil)
+	if err != nil {
+		t.Fatalf("IssueJWT: %v", err)
+	}
+
+	ctx.JWT.CurrentKeyID = "k2"
+	newToken, err := IssueJWT(ctx, "user-1", nil)
+	if err != nil {
+		t.Fatalf("IssueJWT: %v", err)
+	}
+
+	for _, token := range []string{oldToken, newToken} {
+		_, err = VerifyJWT(ctx, token, nil)
+		if err != nil {
+			t.Fatalf("Token of a known key was rejected: %v", err)
+		}
+	}
+
+	delete(ctx.JWT.Keys, "k1") // Retired
+	_, err = VerifyJWT(ctx, oldToken, nil)
+	expectJWTError(t, err, "unknown key k1")
+	_, err = VerifyJWT(ctx, newToken, nil)
+	if err != nil {
+		t.Fatalf("Token of the current key was rejected: %v", err)
+	}
+
+	claims := JWTClaims{ExpiresAt: now.Add(time.Hour).Unix()}
+	forged := signTestJWT(t, jwtHeader{Algorithm: "HS256", Type: "JWT", KeyID: "k3"}, claims, []byte("first secret"))
+	_, err = VerifyJWT(ctx, forged, nil)
+	expectJWTError(t, err, "unknown key k3")
+}
+
+func TestJWTRejectsOtherAlgorithms(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ctx := newTestJWTContext(t, &now)
+	claims := JWTClaims{Issuer: "issuer", Audience: "audience", ExpiresAt: now.Add(time.Hour).Unix()}
+
+	for _, algorithm := range []string{"none", "HS512", "RS256", "hs256"} {
+		token := signTestJWT(t, jwtHeader{Algorithm: algorithm, Type: "JWT", KeyID: "k1"}, claims, ctx.JWT.Keys["k1"])
+		_, err := VerifyJWT(ctx, token, nil)
+		expectJWTError(t, err, "unsupported algorithm "+algorithm)
+	}
+}
+
+func TestJWTCustomClaims(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ctx := newTestJWTContext(t, &now)
+
+	custom := struct {
+		testClaims
+		Subject string `json:"sub"`
+	}{testClaims: testClaims{Role: "admin", Level: 3}, Subject: "spoofed"}
+	token, err := IssueJWT(ctx, "user-1", custom)
+	if err != nil {
+		t.Fatalf("IssueJWT: %v", err)
+	}
+
+	var decoded testClaims
+	claims, err := VerifyJWT(ctx, token, &decoded)
+	if err != nil {
+		t.Fatalf("VerifyJWT: %v", err)
+	}
+	if decoded.Role != "admin" || decoded.Level != 3 {
+		t.Fatalf("Unexpected custom claims %+v", decoded)
+	}
+	if claims.Subject != "user-1" {
+		t.Fatalf("Custom claims overrode the subject: %v", claims.Subject)
+	}
+
+	var wrongType struct {
+		Role int `json:"role"`
+	}
+	_, err = VerifyJWT(ctx, token, &wrongType)
+	var jwtError JWTError
+	if !errors.As(err, &jwtError) {
+		t.Fatalf("Expected JWTError for claims of the wrong type, got %v", err)
+	}
+}
@@ -0,0 +1,375 @@
+package easyframework
+
+import (
+	"crypto/sha256"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+const BUCKET_LOGIN_ATTEMPTS BucketID = "ef_login_attempts"
+
+const PERMISSION_LOGIN_GUARD_ADMIN = "login_guard.admin"
+
+type LoginGuardParams struct {
+	MaxFailures     int           // Failures in a row before the lockout, default is 5
+	BaseDelay       time.Duration // Wait after the first failure, doubled with every next one, default is 1 second
+	MaxDelay        time.Duration // Default is 1 minute
+	LockoutDuration time.Duration // Default is 15 minutes
+	ResetAfter      time.Duration // Failures older than this are forgotten, default is 1 hour
+	AdminProcedures bool          // Register LoginGuard.Unlock, it requires PERMISSION_LOGIN_GUARD_ADMIN
+}
+
+// LoginAttempts are tracked both per username and per IP, entries expire once there is nothing left to remember
+type LoginAttempts struct {
+	Failures      int32 `id:"1"`
+	LastFailureAt int64 `id:"2"`
+	LockedUntil   int64 `id:"3"`
+	Pending       int32 `id:"4"` // Attempts let through by GuardLogin and not reported yet, they count as failures
+	PendingAt     int64 `id:"5"`
+}
+
+// An attempt let through by GuardLogin that wasn't reported with LoginFailed or LoginSucceeded within this no longer counts
+const LOGIN_ATTEMPT_TIMEOUT = time.Minute
+
+type AccountLockedProblem struct {
+	Problem
+	UnlockAt time.Time
+}
+
+func InitializeLoginGuard(ctx *Context, params LoginGuardParams) error {
+	if params.MaxFailures == 0 {
+		params.MaxFailures = 5
+	}
+	if params.BaseDelay == 0 {
+		params.BaseDelay = time.Second
+	}
+	if params.MaxDelay == 0 {
+		params.MaxDelay = time.Minute
+	}
+	if params.LockoutDuration == 0 {
+		params.LockoutDuration = time.Minute * 15
+	}
+	if params.ResetAfter == 0 {
+		params.ResetAfter = time.Hour
+	}
+
+	err := NewBucket(ctx, BUCKET_LOGIN_ATTEMPTS)
+	if err != nil {
+		return err
+	}
+
+	ctx.LoginGuard = &params
+
+	if params.AdminProcedures {
+		NewRPC(ctx, NewRPCParams{
+			Name:        "LoginGuard.Unlock",
+			Handler:     RPC_UnlockLogin,
+			Category:    "Login guard",
+			Description: "Clear failed login attempts of a username and/or an IP",
+			Permissions: []string{PERMISSION_LOGIN_GUARD_ADMIN},
+		})
+	}
+
+	return nil
+}
+
+func loginAttemptsKeys(username, ip string) []string {
+	var keys []string
+	if username != "" {
+		keys = append(keys, "user:"+strings.ToLower(username))
+	}
+	if ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+	return keys
+}
+
+// loginAttemptsID hashes the key to fit ID128, so entries can expire
+func loginAttemptsID(key string) (ID ID128) {
+	hash := sha256.Sum256([]byte(key))
+	copy(ID[:], hash[:])
+	return ID
+}
+
+// getLoginAttempts reads the entry with failures older than ResetAfter and abandoned attempts forgotten
+func getLoginAttempts(params *LoginGuardParams, bucket Bucket, ID ID128, now time.Time) (attempts LoginAttempts, err error) {
+	data := bucket.Get(ID[:])
+	if data == nil || IsExpired(bucket, ID) {
+		return attempts, nil
+	}
+	err = Unpack(data, &attempts)
+	if err != nil {
+		return attempts, err
+	}
+
+	if now.Sub(time.Unix(attempts.LastFailureAt, 0)) > params.ResetAfter {
+		attempts.Failures = 0
+	}
+	if now.Sub(time.Unix(attempts.PendingAt, 0)) > LOGIN_ATTEMPT_TIMEOUT {
+		attempts.Pending = 0
+	}
+	return attempts, nil
+}
+
+// putLoginAttempts stores the entry until its failures are forgotten, its lock is over and its attempts time out
+func putLoginAttempts(params *LoginGuardParams, tx Tx, ID ID128, attempts *LoginAttempts) error {
+	if attempts.Failures == 0 && attempts.Pending == 0 && attempts.LockedUntil <= time.Now().Unix() {
+		bucket, err := GetBucket(tx, BUCKET_LOGIN_ATTEMPTS)
+		if err != nil {
+			return err
+		}
+		return Delete[LoginAttempts](bucket, ID)
+	}
+
+	expiresAt := time.Unix(attempts.LastFailureAt, 0).Add(params.ResetAfter)
+	if lockedUntil := time.Unix(attempts.LockedUntil, 0); lockedUntil.After(expiresAt) {
+		expiresAt = lockedUntil
+	}
+	if pendingUntil := time.Unix(attempts.PendingAt, 0).Add(LOGIN_ATTEMPT_TIMEOUT); attempts.Pending > 0 && pendingUntil.After(expiresAt) {
+		expiresAt = pendingUntil
+	}
+	return InsertWithExpiry(tx, BUCKET_LOGIN_ATTEMPTS, ID, attempts, expiresAt)
+}
+
+// lockedUntil tells until when the entry allows no more attempts, zero if it allows them now
+func (attempts *LoginAttempts) lockedUntil(params *LoginGuardParams, now time.Time) time.Time {
+	if attempts.LockedUntil > now.Unix() {
+		return time.Unix(attempts.LockedUntil, 0)
+	}
+	if attempts.Pending > 0 && int(attempts.Failures+attempts.Pending) >= params.MaxFailures { // The attempts in flight might use up what is left
+		return time.Unix(attempts.PendingAt, 0).Add(LOGIN_ATTEMPT_TIMEOUT)
+	}
+	return time.Time{}
+}
+
+// CheckLogin tells whether a login attempt for the username from the ip is allowed right now
+func CheckLogin(ctx *Context, username, ip string) (unlockAt time.Time, locked bool, err error) {
+	now := time.Now()
+	err = ctx.Database.View(func(tx Tx) error {
+		bucket, err := GetBucket(tx, BUCKET_LOGIN_ATTEMPTS)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range loginAttemptsKeys(username, ip) {
+			attempts, err := getLoginAttempts(ctx.LoginGuard, bucket, loginAttemptsID(key), now)
+			if err != nil {
+				return err
+			}
+			if until := attempts.lockedUntil(ctx.LoginGuard, now); until.After(unlockAt) {
+				unlockAt = until
+				locked = true
+			}
+		}
+
+		return nil
+	})
+
+	return unlockAt, locked, err
+}
+
+/*
+ReserveLoginAttempt is CheckLogin that also counts the attempt as pending in the same transaction, so concurrent
+attempts can't all pass the check before any of them fails. Report the outcome with RecordLoginFailure or
+RecordLoginSuccess, a pending attempt counts as a failure until then.
+*/
+func ReserveLoginAttempt(ctx *Context, username, ip string) (unlockAt time.Time, locked bool, err error) {
+	params := ctx.LoginGuard
+	now := time.Now()
+	err = ctx.Database.Update(func(tx Tx) error {
+		bucket, err := GetBucket(tx, BUCKET_LOGIN_ATTEMPTS)
+		if err != nil {
+			return err
+		}
+
+		keys := loginAttemptsKeys(username, ip)
+		entries := make([]LoginAttempts, len(keys))
+		for i, key := range keys {
+			entries[i], err = getLoginAttempts(params, bucket, loginAttemptsID(key), now)
+			if err != nil {
+				return err
+			}
+			if until := entries[i].lockedUntil(params, now); until.After(unlockAt) {
+				unlockAt = until
+				locked = true
+			}
+		}
+		if locked {
+			return nil
+		}
+
+		for i, key := range keys {
+			entries[i].Pending += 1
+			entries[i].PendingAt = now.Unix()
+			err := putLoginAttempts(params, tx, loginAttemptsID(key), &entries[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return unlockAt, locked, err
+}
+
+// RecordLoginFailure counts a failure for both the username and the ip, and ends a pending attempt
+func RecordLoginFailure(ctx *Context, username, ip string) error {
+	params := ctx.LoginGuard
+	now := time.Now()
+	return ctx.Database.Update(func(tx Tx) error {
+		bucket, err := GetBucket(tx, BUCKET_LOGIN_ATTEMPTS)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range loginAttemptsKeys(username, ip) {
+			ID := loginAttemptsID(key)
+			attempts, err := getLoginAttempts(params, bucket, ID, now)
+			if err != nil {
+				return err
+			}
+
+			if attempts.Pending > 0 {
+				attempts.Pending -= 1
+			}
+			attempts.Failures += 1
+			attempts.LastFailureAt = now.Unix()
+
+			if int(attempts.Failures) >= params.MaxFailures {
+				attempts.LockedUntil = now.Add(params.LockoutDuration).Unix()
+				log.Printf("Login locked for %v until %v", key, time.Unix(attempts.LockedUntil, 0))
+			} else {
+				delay := params.BaseDelay << (attempts.Failures - 1)
+				if delay > params.MaxDelay || delay <= 0 {
+					delay = params.MaxDelay
+				}
+				attempts.LockedUntil = now.Add(delay).Unix()
+			}
+
+			err = putLoginAttempts(params, tx, ID, &attempts)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// RecordLoginSuccess forgets failures of the username and ends a pending attempt of the ip, failures of the IP stay
+// so one good account doesn't reset them
+func RecordLoginSuccess(ctx *Context, username, ip string) error {
+	params := ctx.LoginGuard
+	now := time.Now()
+	return ctx.Database.Update(func(tx Tx) error {
+		bucket, err := GetBucket(tx, BUCKET_LOGIN_ATTEMPTS)
+		if err != nil {
+			return err
+		}
+
+		err = unlockLogin(bucket, username, "")
+		if err != nil {
+			return err
+		}
+
+		for _, key := range loginAttemptsKeys("", ip) {
+			ID := loginAttemptsID(key)
+			attempts, err := getLoginAttempts(params, bucket, ID, now)
+			if err != nil {
+				return err
+			}
+			if attempts.Pending == 0 {
+				continue
+			}
+
+			attempts.Pending -= 1
+			err = putLoginAttempts(params, tx, ID, &attempts)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func UnlockLogin(ctx *Context, username, ip string) error {
+	return ctx.Database.Update(func(tx Tx) error {
+		bucket, err := GetBucket(tx, BUCKET_LOGIN_ATTEMPTS)
+		if err != nil {
+			return err
+		}
+		return unlockLogin(bucket, username, ip)
+	})
+}
+
+func unlockLogin(bucket Bucket, username, ip string) error {
+	for _, key := range loginAttemptsKeys(username, ip) {
+		err := Delete[LoginAttempts](bucket, loginAttemptsID(key))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GuardLogin is meant to be called at the start of a login procedure. If ok is false the problem should be returned as is,
+// otherwise the outcome must be reported with LoginFailed or LoginSucceeded.
+func GuardLogin(requestContext *RequestContext, username string) (problem AccountLockedProblem, ok bool) {
+	ip, _, _ := net.SplitHostPort(requestContext.Request.RemoteAddr)
+
+	unlockAt, locked, err := ReserveLoginAttempt(requestContext.Context, username, ip)
+	if err != nil {
+		log.Printf("Login guard failed: %v", err)
+		problem.ErrorID = ERROR_INTERNAL
+		return problem, false
+	}
+	if locked {
+		problem.ErrorID = ERROR_ACCOUNT_LOCKED
+		problem.Message = "Too many failed login attempts"
+		problem.UnlockAt = unlockAt
+		return problem, false
+	}
+
+	return problem, true
+}
+
+// LoginFailed records the failure for both the username and the IP of the request
+func LoginFailed(requestContext *RequestContext, username string) {
+	ip, _, _ := net.SplitHostPort(requestContext.Request.RemoteAddr)
+
+	err := RecordLoginFailure(requestContext.Context, username, ip)
+	if err != nil {
+		log.Printf("Failed to record login failure: %v", err)
+	}
+}
+
+func LoginSucceeded(requestContext *RequestContext, username string) {
+	ip, _, _ := net.SplitHostPort(requestContext.Request.RemoteAddr)
+
+	err := RecordLoginSuccess(requestContext.Context, username, ip)
+	if err != nil {
+		log.Printf("Failed to record login success: %v", err)
+	}
+}
+
+type UnlockLoginRequest struct {
+	Username string
+	IP       string
+}
+
+func RPC_UnlockLogin(requestContext *RequestContext, request UnlockLoginRequest) (problem Problem) {
+	if request.Username == "" && request.IP == "" {
+		problem.ErrorID = ERROR_VALIDATION_FAILED
+		problem.Message = "Username or IP is required"
+		return
+	}
+
+	err := UnlockLogin(requestContext.Context, request.Username, request.IP)
+	if err != nil {
+		log.Printf("Failed to unlock login: %v", err)
+		problem.ErrorID = ERROR_INTERNAL
+	}
+	return
+}
@@ -0,0 +1,94 @@
+package easyframework
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newLoginGuardTestContext(t *testing.T, delay time.Duration) *Context {
+	t.Helper()
+	ctx := new(Context)
+	params := LoginGuardParams{MaxFailures: 3, BaseDelay: delay, MaxDelay: delay}
+	err := Initialize(ctx, InitializeParams{Storage: NewMemoryStorage(), LoginGuard: &params})
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	return ctx
+}
+
+func TestReserveLoginAttemptIsAtomic(t *testing.T) {
+	ctx := newLoginGuardTestContext(t, 0)
+
+	var allowed atomic.Int32
+	var wait sync.WaitGroup
+	for i := 0; i < 10; i += 1 {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			_, locked, err := ReserveLoginAttempt(ctx, "alice", "")
+			if err != nil {
+				t.Errorf("ReserveLoginAttempt: %v", err)
+			}
+			if !locked {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wait.Wait()
+	if allowed.Load() != 3 {
+		t.Fatalf("%v concurrent attempts were allowed, expected MaxFailures", allowed.Load())
+	}
+
+	err := RecordLoginSuccess(ctx, "alice", "")
+	if err != nil {
+		t.Fatalf("RecordLoginSuccess: %v", err)
+	}
+	_, locked, err := CheckLogin(ctx, "alice", "")
+	if err != nil || locked {
+		t.Fatalf("Login is still locked after a success: %v", err)
+	}
+}
+
+func TestLoginFailuresLockAndExpire(t *testing.T) {
+	ctx := newLoginGuardTestContext(t, time.Nanosecond) // No wait between attempts
+
+	for i := 0; i < 3; i += 1 {
+		_, locked, err := ReserveLoginAttempt(ctx, "bob", "10.0.0.1")
+		if err != nil || locked {
+			t.Fatalf("Attempt %v was rejected: %v", i, err)
+		}
+		err = RecordLoginFailure(ctx, "bob", "10.0.0.1")
+		if err != nil {
+			t.Fatalf("RecordLoginFailure: %v", err)
+		}
+	}
+
+	unlockAt, locked, err := CheckLogin(ctx, "bob", "")
+	if err != nil || !locked || unlockAt.Before(time.Now().Add(14*time.Minute)) {
+		t.Fatalf("Expected a lockout after MaxFailures, got %v %v %v", unlockAt, locked, err)
+	}
+
+	ctx.Database.View(func(tx Tx) error {
+		bucket := tx.Bucket([]byte(BUCKET_LOGIN_ATTEMPTS))
+		expiresAt, ok := GetExpiry(bucket, loginAttemptsID("user:bob"))
+		if !ok || expiresAt.Before(unlockAt) {
+			t.Fatalf("Entry expires at %v %v, before the lock is over", expiresAt, ok)
+		}
+		return nil
+	})
+
+	err = UnlockLogin(ctx, "bob", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("UnlockLogin: %v", err)
+	}
+	ctx.Database.View(func(tx Tx) error {
+		bucket := tx.Bucket([]byte(BUCKET_LOGIN_ATTEMPTS))
+		ID := loginAttemptsID("user:bob")
+		if _, ok := GetExpiry(bucket, ID); ok || bucket.Get(ID[:]) != nil {
+			t.Fatalf("Unlocked entry or its expiry is still there")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,322 @@
+package easyframework
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+)
+
+const BUCKET_METADATA BucketID = "ef_metadata"
+
+type Migration struct {
+	Name string // Migrations are recorded by name, it must never change once the migration was applied
+
+	// Record migrations go over every record of the Bucket in batches, one transaction per batch.
+	// Returning nil data removes the record. Versions, expiry and indexes are kept in sync the way Insert and Delete
+	// do it, indexes are rebuilt for Type once every record is migrated. Subscribers and the change log are not told,
+	// records change their type here and a Change of the old type as the new one means nothing.
+	Bucket    BucketID
+	BatchSize int // Default is 1000
+	Record    func(ID ID128, data []byte) ([]byte, error)
+	Type      reflect.Type // Type of the migrated records, indexes are rebuilt for it. Default is the type registered with RegisterBucketType.
+
+	// Run is for everything else, it is done in a single transaction. Ignored for record migrations.
+	Run func(tx Tx) error
+}
+
+type MigrationRecord struct {
+	AppliedAt int64 `id:"1"`
+	Records   int64 `id:"2"`
+}
+
+// RecordMigration unpacks every record as Old and stores what transform returns. Returning nil removes the record.
+func RecordMigration[Old any, New any](name string, bucketID BucketID, transform func(ID ID128, old *Old) (*New, error)) Migration {
+	return Migration{
+		Name:   name,
+		Bucket: bucketID,
+		Type:   reflect.TypeOf((*New)(nil)).Elem(),
+		Record: func(ID ID128, data []byte) ([]byte, error) {
+			var old Old
+			err := Unpack(data, &old)
+			if err != nil {
+				return nil, err
+			}
+
+			value, err := transform(ID, &old)
+			if err != nil || value == nil {
+				return nil, err
+			}
+
+			return Pack(value)
+		},
+	}
+}
+
+func migrationKey(name string) []byte {
+	return []byte("migration:" + name)
+}
+
+func migrationProgressKey(name string) []byte {
+	return []byte("migration_progress:" + name)
+}
+
+func IsMigrationApplied(ctx *Context, name string) (applied bool, err error) {
+	err = ctx.Database.View(func(tx Tx) error {
+		metadata, err := GetBucket(tx, BUCKET_METADATA)
+		if err != nil {
+			return err
+		}
+
+		applied = metadata.Get(migrationKey(name)) != nil
+		return nil
+	})
+	return applied, err
+}
+
+// RunMigrations applies migrations that were not applied yet, in order. With dryRun nothing is written, only reported.
+func RunMigrations(ctx *Context, migrations []Migration, dryRun bool) error {
+	err := NewBucket(ctx, BUCKET_METADATA)
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]bool)
+	for i, migration := range migrations {
+		if migration.Name == "" {
+			return fmt.Errorf("Migration %v has no name", i)
+		}
+		if names[migration.Name] {
+			return fmt.Errorf("Migration %v is listed twice", migration.Name)
+		}
+		names[migration.Name] = true
+
+		if migration.Record == nil && migration.Run == nil {
+			return fmt.Errorf("Migration %v has neither Record nor Run", migration.Name)
+		}
+		if migration.Record != nil && migration.Bucket == "" {
+			return fmt.Errorf("Migration %v has Record but no Bucket", migration.Name)
+		}
+	}
+
+	for _, migration := range migrations {
+		applied, err := IsMigrationApplied(ctx, migration.Name)
+		if err != nil {
+			return err
+		}
+		if applied {
+			continue
+		}
+
+		if dryRun {
+			log.Printf("Migration %v: dry run", migration.Name)
+		} else {
+			log.Printf("Migration %v: applying", migration.Name)
+		}
+		start := time.Now()
+
+		var records int64
+		if migration.Record != nil {
+			records, err = runRecordMigration(ctx, migration, dryRun)
+		} else if !dryRun {
+			err = ctx.Database.Update(func(tx Tx) error {
+				err := migration.Run(tx)
+				if err != nil {
+					return err
+				}
+				return markMigrationApplied(tx, migration.Name, 0)
+			})
+		}
+		if err != nil {
+			log.Printf("Migration %v: FAILED: %v", migration.Name, err)
+			return fmt.Errorf("Migration %v failed: %w", migration.Name, err)
+		}
+
+		log.Printf("Migration %v: done in %v, %v records", migration.Name, time.Since(start), records)
+	}
+
+	return nil
+}
+
+func markMigrationApplied(tx Tx, name string, records int64) error {
+	metadata, err := GetBucket(tx, BUCKET_METADATA)
+	if err != nil {
+		return err
+	}
+
+	data, err := Pack(&MigrationRecord{
+		AppliedAt: time.Now().Unix(),
+		Records:   records,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = metadata.Delete(migrationProgressKey(name))
+	if err != nil {
+		return err
+	}
+	return metadata.Put(migrationKey(name), data)
+}
+
+type migratedRecord struct {
+	Key  []byte
+	Data []byte
+}
+
+// runRecordMigration remembers the last migrated key after every batch, so a failed migration continues where it stopped
+func runRecordMigration(ctx *Context, migration Migration, dryRun bool) (records int64, err error) {
+	batchSize := migration.BatchSize
+	if batchSize == 0 {
+		batchSize = 1000
+	}
+
+	var lastKey []byte
+	if !dryRun {
+		err = ctx.Database.View(func(tx Tx) error {
+			metadata, err := GetBucket(tx, BUCKET_METADATA)
+			if err != nil {
+				return err
+			}
+			if progress := metadata.Get(migrationProgressKey(migration.Name)); progress != nil {
+				lastKey = append([]byte(nil), progress...)
+				log.Printf("Migration %v: continuing after %v", migration.Name, ID128(lastKey))
+			}
+			return nil
+		})
+		if err != nil {
+			return records, err
+		}
+	}
+
+	for batch := 1; ; batch += 1 {
+		done := false
+		batchProcedure := func(tx Tx) error {
+			bucket := tx.Bucket([]byte(migration.Bucket))
+			if bucket == nil { // Fresh database, there is nothing to migrate
+				done = true
+				if dryRun {
+					return nil
+				}
+				return markMigrationApplied(tx, migration.Name, records)
+			}
+			typeof := migration.Type
+			if bucketType, registered := lookupBucketType(migration.Bucket); typeof == nil && registered {
+				typeof = bucketType.Type
+			}
+			if typeof == nil && hasIndexBuckets(bucket) {
+				return fmt.Errorf("bucket has indexes, set Type or RegisterBucketType so they can be rebuilt")
+			}
+
+			var err error
+			var migrated []migratedRecord
+			cursor := bucket.Cursor()
+			var key, data []byte
+			if lastKey == nil {
+				key, data = cursor.First()
+			} else {
+				key, data = cursor.Seek(lastKey)
+				if key != nil && bytes.Equal(key, lastKey) {
+					key, data = cursor.Next()
+				}
+			}
+			for ; key != nil && len(migrated) < batchSize; key, data = cursor.Next() {
+				if data == nil { // Nested bucket (indexes)
+					continue
+				}
+
+				newData, err := migration.Record(ID128(key), data)
+				if err != nil {
+					return fmt.Errorf("record %v: %w", ID128(key), err)
+				}
+				migrated = append(migrated, migratedRecord{
+					Key:  append([]byte(nil), key...),
+					Data: newData,
+				})
+			}
+			done = key == nil
+
+			if len(migrated) > 0 {
+				lastKey = migrated[len(migrated)-1].Key
+			}
+			records += int64(len(migrated))
+			if dryRun {
+				return nil
+			}
+
+			for _, record := range migrated { // Not under the cursor, it doesn't like the bucket changing
+				if record.Data == nil {
+					err = deleteUntyped(bucket, ID128(record.Key)) // Index entries too, the rebuild only covers records that are left
+				} else {
+					err = bucket.Put(record.Key, record.Data)
+					if err == nil {
+						err = bumpVersion(bucket, ID128(record.Key))
+					}
+				}
+				if err != nil {
+					return err
+				}
+			}
+
+			if !done {
+				metadata, err := GetBucket(tx, BUCKET_METADATA)
+				if err != nil {
+					return err
+				}
+				return metadata.Put(migrationProgressKey(migration.Name), lastKey)
+			}
+
+			// Index entries were computed from the old records, they are rebuilt from the new ones
+			for _, name := range nestedBuckets(bucket) {
+				if bytes.HasPrefix(name, []byte(INDEX_BUCKET_PREFIX)) {
+					err := bucket.DeleteBucket(name)
+					if err != nil {
+						return err
+					}
+				}
+			}
+			if typeof != nil {
+				err = ensureIndexBuckets(bucket, typeof, GetIndexes(typeof))
+				if err != nil {
+					return fmt.Errorf("rebuilding indexes: %w", err)
+				}
+			}
+			return markMigrationApplied(tx, migration.Name, records)
+		}
+
+		if dryRun {
+			err = ctx.Database.View(batchProcedure)
+		} else {
+			err = ctx.Database.Update(batchProcedure)
+		}
+		if err != nil {
+			return records, err
+		}
+
+		log.Printf("Migration %v: batch %v, %v records so far", migration.Name, batch, records)
+		if done {
+			return records, nil
+		}
+	}
+}
+
+func hasIndexBuckets(bucket Bucket) bool {
+	for _, name := range nestedBuckets(bucket) {
+		if bytes.HasPrefix(name, []byte(INDEX_BUCKET_PREFIX)) {
+			return true
+		}
+	}
+	return false
+}
+
+func nestedBuckets(bucket Bucket) [][]byte {
+	var names [][]byte
+	cursor := bucket.Cursor()
+	for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+		if value == nil {
+			names = append(names, append([]byte(nil), key...))
+		}
+	}
+	return names
+}
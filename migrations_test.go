@@ -0,0 +1,145 @@
+package easyframework
+
+import (
+	"testing"
+	"time"
+)
+
+type migrationTestOld struct {
+	Name string `id:"1"`
+}
+
+type migrationTestNew struct {
+	Name  string `id:"1" index:"migration_test_name,unique"`
+	Count int32  `id:"2"`
+}
+
+func upperMigration() Migration {
+	return RecordMigration("add_count", "migration_test", func(ID ID128, old *migrationTestOld) (*migrationTestNew, error) {
+		if old.Name == "removed" {
+			return nil, nil
+		}
+		return &migrationTestNew{Name: old.Name, Count: 1}, nil
+	})
+}
+
+func TestRecordMigrationOnEmptyDatabase(t *testing.T) {
+	ctx := new(Context)
+	err := Initialize(ctx, InitializeParams{Storage: NewMemoryStorage(), Migrations: []Migration{upperMigration()}})
+	if err != nil {
+		t.Fatalf("Initialize on an empty database: %v", err)
+	}
+
+	applied, err := IsMigrationApplied(ctx, "add_count")
+	if err != nil || !applied {
+		t.Fatalf("Migration is not marked applied: %v %v", applied, err)
+	}
+}
+
+func TestRecordMigration(t *testing.T) {
+	ctx := new(Context)
+	err := Initialize(ctx, InitializeParams{Storage: NewMemoryStorage()})
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	err = NewBucket(ctx, "migration_test")
+	if err != nil {
+		t.Fatalf("NewBucket: %v", err)
+	}
+
+	err = EnableVersions(ctx, "migration_test")
+	if err != nil {
+		t.Fatalf("EnableVersions: %v", err)
+	}
+
+	kept, removed := NewID128(), NewID128()
+	err = InsertByID(ctx, "migration_test", kept, &migrationTestOld{Name: "kept"})
+	if err != nil {
+		t.Fatalf("InsertByID: %v", err)
+	}
+	err = InsertByIDWithTTL(ctx, "migration_test", removed, &migrationTestOld{Name: "removed"}, time.Hour)
+	if err != nil {
+		t.Fatalf("InsertByIDWithTTL: %v", err)
+	}
+	var keptVersion int64
+	ctx.Database.View(func(tx Tx) error {
+		keptVersion = GetVersion(tx.Bucket([]byte("migration_test")), kept)
+		return nil
+	})
+
+	migrations := []Migration{upperMigration()}
+	err = RunMigrations(ctx, migrations, true)
+	if err != nil {
+		t.Fatalf("Dry run: %v", err)
+	}
+	if applied, _ := IsMigrationApplied(ctx, "add_count"); applied {
+		t.Fatalf("Dry run marked the migration applied")
+	}
+
+	err = RunMigrations(ctx, migrations, false)
+	if err != nil {
+		t.Fatalf("RunMigrations: %v", err)
+	}
+
+	var value migrationTestNew
+	if !GetByID(ctx, "migration_test", kept, &value) || value.Count != 1 {
+		t.Fatalf("Record was not migrated: %+v", value)
+	}
+	if GetByID(ctx, "migration_test", removed, &value) {
+		t.Fatalf("Record the migration removed is still there")
+	}
+	err = ctx.Database.View(func(tx Tx) error {
+		bucket := tx.Bucket([]byte("migration_test"))
+		ID, _, found, err := FindByIndex[migrationTestNew](bucket, "migration_test_name", "kept")
+		if err != nil || !found || ID != kept {
+			t.Fatalf("Index was not rebuilt: %v %v %v", ID, found, err)
+		}
+		if version := GetVersion(bucket, kept); version <= keptVersion {
+			t.Fatalf("Version of the migrated record is %v, it was %v before", version, keptVersion)
+		}
+		if GetVersion(bucket, removed) != 0 {
+			t.Fatalf("Removed record still has a version")
+		}
+		if _, expires := GetExpiry(bucket, removed); expires {
+			t.Fatalf("Removed record still has an expiry")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %v", err)
+	}
+
+	// Applied migrations are skipped, even if they would fail now
+	err = RunMigrations(ctx, []Migration{{Name: "add_count", Run: func(tx Tx) error { panic("applied twice") }}}, false)
+	if err != nil {
+		t.Fatalf("RunMigrations again: %v", err)
+	}
+}
+
+func TestRunMigrationsValidates(t *testing.T) {
+	ctx := new(Context)
+	err := Initialize(ctx, InitializeParams{Storage: NewMemoryStorage()})
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+
+	ran := false
+	run := func(tx Tx) error {
+		ran = true
+		return nil
+	}
+	for name, migrations := range map[string][]Migration{
+		"no name":           {{Run: run}},
+		"listed twice":      {{Name: "a", Run: run}, {Name: "a", Run: run}},
+		"nothing to do":     {{Name: "a", Run: run}, {Name: "b"}},
+		"record, no bucket": {{Name: "a", Record: func(ID ID128, data []byte) ([]byte, error) { return data, nil }}},
+	} {
+		err := RunMigrations(ctx, migrations, false)
+		if err == nil {
+			t.Fatalf("%v: expected an error", name)
+		}
+	}
+	if ran {
+		t.Fatalf("A migration ran although the list is invalid")
+	}
+}
@@ -0,0 +1,158 @@
+package easyframework
+
+import (
+	"encoding/base64"
+	"reflect"
+)
+
+const DEFAULT_PAGE_SIZE = 50
+const MAX_PAGE_SIZE = 1000
+
+// PageRequest can be the request of a list procedure or be embedded into it
+type PageRequest struct {
+	Cursor  string `description:"NextCursor of the previous page, empty for the first page"`
+	Limit   int    `description:"Page size, default is 50, at most 1000"`
+	Reverse bool   `description:"Descending key order"`
+}
+
+type PageResponse[T any] struct {
+	Items      []T
+	NextCursor string `description:"Pass as Cursor to get the next page, empty on the last page"`
+	HasMore    bool
+}
+
+func (response PageResponse[T]) isPageResponse() {}
+
+type pageResponse interface {
+	isPageResponse()
+}
+
+type InvalidCursorError struct{}
+
+func (v InvalidCursorError) Error() string {
+	return "Invalid page cursor"
+}
+
+// EncodeCursor makes an opaque cursor that continues after the key
+func EncodeCursor(key []byte) string {
+	return base64.RawURLEncoding.EncodeToString(key)
+}
+
+func DecodeCursor(cursor string) ([]byte, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(key) == 0 {
+		return nil, InvalidCursorError{}
+	}
+	return key, nil
+}
+
+func (request PageRequest) PageSize() int {
+	if request.Limit <= 0 {
+		return DEFAULT_PAGE_SIZE
+	}
+	if request.Limit > MAX_PAGE_SIZE {
+		return MAX_PAGE_SIZE
+	}
+	return request.Limit
+}
+
+// Paginate returns one page of records in key order, only the page is read from the bucket
+func Paginate[T any](bucket Bucket, request PageRequest) (PageResponse[T], error) {
+	return PaginateFilter[T](bucket, request, nil)
+}
+
+// PaginateFilter is Paginate that leaves out records the condition returns false for, nil condition keeps everything.
+// The next page can be empty even if HasMore is set, when the condition rejects every remaining record.
+func PaginateFilter[T any](bucket Bucket, request PageRequest, condition func(ID ID128, value *T) bool) (PageResponse[T], error) {
+	var response PageResponse[T]
+	limit := request.PageSize()
+
+	var after []byte
+	if request.Cursor != "" {
+		var err error
+		after, err = DecodeCursor(request.Cursor)
+		if err != nil {
+			return response, err
+		}
+	}
+
+	cursor := bucket.Cursor()
+	var key, data []byte
+	var next func() ([]byte, []byte)
+	if request.Reverse {
+		next = cursor.Prev
+		if after == nil {
+			key, data = cursor.Last()
+		} else {
+			key, data = cursor.Seek(after)
+			if key == nil {
+				key, data = cursor.Last()
+			} else { // Seek lands on the key or the one after it, both are already past the page start
+				key, data = cursor.Prev()
+			}
+		}
+	} else {
+		next = cursor.Next
+		if after == nil {
+			key, data = cursor.First()
+		} else {
+			key, data = cursor.Seek(after)
+			if key != nil && string(key) == string(after) {
+				key, data = cursor.Next()
+			}
+		}
+	}
+
+	expiry := NewExpiryChecker(bucket)
+	var lastKey []byte
+	for ; key != nil; key, data = next() {
+		if data == nil || expiry.IsExpired(key) { // Nested buckets, expired records
+			continue
+		}
+
+		if len(response.Items) == limit {
+			response.HasMore = true
+			break
+		}
+
+		var value T
+		err := Unpack(data, &value)
+		if err != nil {
+			return response, RecordUnpackError{ID: ID128(key), Err: err}
+		}
+		if condition != nil && !condition(ID128(key), &value) {
+			continue
+		}
+
+		response.Items = append(response.Items, value)
+		lastKey = key
+	}
+
+	if response.HasMore {
+		response.NextCursor = EncodeCursor(lastKey)
+	}
+	if response.Items == nil {
+		response.Items = []T{} // [] instead of null in JSON
+	}
+	return response, nil
+}
+
+// isPaginated tells the documentation whether a procedure takes a PageRequest or returns a PageResponse
+func isPaginated(inputType, outputType reflect.Type) bool {
+	pageRequestType := reflect.TypeOf(PageRequest{})
+	if inputType != nil {
+		if inputType == pageRequestType {
+			return true
+		}
+		if inputType.Kind() == reflect.Struct {
+			for i := 0; i < inputType.NumField(); i += 1 {
+				field := inputType.Field(i)
+				if field.Anonymous && field.Type == pageRequestType {
+					return true
+				}
+			}
+		}
+	}
+
+	return outputType != nil && outputType.Implements(reflect.TypeOf((*pageResponse)(nil)).Elem())
+}
@@ -0,0 +1,208 @@
+package easyframework
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type paginationTestRecord struct {
+	Number int64 `id:"1"`
+}
+
+// paginationTestID makes IDs that sort by the number
+func paginationTestID(number int64) ID128 {
+	var ID ID128
+	ID[15] = byte(number)
+	return ID
+}
+
+func newPaginationTestContext(t *testing.T, storage Storage, count int64) *Context {
+	t.Helper()
+	ctx := new(Context)
+	err := Initialize(ctx, InitializeParams{Storage: storage})
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	err = NewBucket(ctx, "pagination_test")
+	if err != nil {
+		t.Fatalf("NewBucket: %v", err)
+	}
+	for number := int64(1); number <= count; number += 1 {
+		err := InsertByID(ctx, "pagination_test", paginationTestID(number), &paginationTestRecord{Number: number})
+		if err != nil {
+			t.Fatalf("InsertByID: %v", err)
+		}
+	}
+	return ctx
+}
+
+// readAllPages follows NextCursor to the end and returns the numbers in the order they came
+func readAllPages(t *testing.T, ctx *Context, request PageRequest, condition func(ID ID128, value *paginationTestRecord) bool) []int64 {
+	t.Helper()
+	var numbers []int64
+	for pages := 0; ; pages += 1 {
+		if pages > 100 {
+			t.Fatalf("Pagination doesn't end")
+		}
+		var page PageResponse[paginationTestRecord]
+		err := ctx.Database.View(func(tx Tx) error {
+			var err error
+			page, err = PaginateFilter(tx.Bucket([]byte("pagination_test")), request, condition)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("PaginateFilter: %v", err)
+		}
+		if len(page.Items) > request.PageSize() {
+			t.Fatalf("Page has %v items, limit is %v", len(page.Items), request.PageSize())
+		}
+		for _, item := range page.Items {
+			numbers = append(numbers, item.Number)
+		}
+		if !page.HasMore {
+			if page.NextCursor != "" {
+				t.Fatalf("Last page has a cursor")
+			}
+			return numbers
+		}
+		request.Cursor = page.NextCursor
+	}
+}
+
+func expectNumbers(t *testing.T, got []int64, expected ...int64) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, got)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, got)
+		}
+	}
+}
+
+func TestPaginateForwardAndReverse(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage) {
+		ctx := newPaginationTestContext(t, storage, 7)
+
+		expectNumbers(t, readAllPages(t, ctx, PageRequest{Limit: 3}, nil), 1, 2, 3, 4, 5, 6, 7)
+		expectNumbers(t, readAllPages(t, ctx, PageRequest{Limit: 3, Reverse: true}, nil), 7, 6, 5, 4, 3, 2, 1)
+		expectNumbers(t, readAllPages(t, ctx, PageRequest{Limit: 7}, nil), 1, 2, 3, 4, 5, 6, 7)
+		expectNumbers(t, readAllPages(t, ctx, PageRequest{}, nil), 1, 2, 3, 4, 5, 6, 7)
+	})
+}
+
+func TestPaginateAfterCursorRecordIsDeleted(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage) {
+		ctx := newPaginationTestContext(t, storage, 7)
+
+		for _, reverse := range []bool{false, true} {
+			var page PageResponse[paginationTestRecord]
+			err := ctx.Database.View(func(tx Tx) error {
+				var err error
+				page, err = Paginate[paginationTestRecord](tx.Bucket([]byte("pagination_test")), PageRequest{Limit: 3, Reverse: reverse})
+				return err
+			})
+			if err != nil {
+				t.Fatalf("Paginate: %v", err)
+			}
+			last := page.Items[len(page.Items)-1].Number
+
+			err = ctx.Database.Update(func(tx Tx) error {
+				return Delete[paginationTestRecord](tx.Bucket([]byte("pagination_test")), paginationTestID(last))
+			})
+			if err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+
+			rest := readAllPages(t, ctx, PageRequest{Cursor: page.NextCursor, Limit: 3, Reverse: reverse}, nil)
+			if reverse {
+				expectNumbers(t, rest, 4, 2, 1) // 3 was deleted by the forward pass
+			} else {
+				expectNumbers(t, rest, 4, 5, 6, 7)
+			}
+		}
+	})
+}
+
+func TestPaginateSkipsExpiredAndFiltered(t *testing.T) {
+	ctx := newPaginationTestContext(t, NewMemoryStorage(), 7)
+	err := ctx.Database.Update(func(tx Tx) error {
+		return InsertWithExpiry(tx, "pagination_test", paginationTestID(3), &paginationTestRecord{Number: 3}, time.Now().Add(-time.Second))
+	})
+	if err != nil {
+		t.Fatalf("InsertWithExpiry: %v", err)
+	}
+
+	expectNumbers(t, readAllPages(t, ctx, PageRequest{Limit: 2}, nil), 1, 2, 4, 5, 6, 7)
+
+	even := func(ID ID128, value *paginationTestRecord) bool { return value.Number%2 == 0 }
+	expectNumbers(t, readAllPages(t, ctx, PageRequest{Limit: 2}, even), 2, 4, 6)
+	expectNumbers(t, readAllPages(t, ctx, PageRequest{Limit: 1, Reverse: true}, even), 6, 4, 2)
+}
+
+func TestPaginateRejectsInvalidCursor(t *testing.T) {
+	ctx := newPaginationTestContext(t, NewMemoryStorage(), 1)
+	for _, cursor := range []string{"not base64!", "=="} {
+		err := ctx.Database.View(func(tx Tx) error {
+			_, err := Paginate[paginationTestRecord](tx.Bucket([]byte("pagination_test")), PageRequest{Cursor: cursor})
+			return err
+		})
+		if !errors.As(err, &InvalidCursorError{}) {
+			t.Fatalf("Cursor %q returned %v", cursor, err)
+		}
+	}
+}
+
+func TestPageSize(t *testing.T) {
+	sizes := map[int]int{-1: DEFAULT_PAGE_SIZE, 0: DEFAULT_PAGE_SIZE, 10: 10, MAX_PAGE_SIZE + 1: MAX_PAGE_SIZE}
+	for limit, expected := range sizes {
+		if size := (PageRequest{Limit: limit}).PageSize(); size != expected {
+			t.Fatalf("Limit %v gave page size %v, expected %v", limit, size, expected)
+		}
+	}
+}
+
+func TestEmptyPageIsNotNull(t *testing.T) {
+	ctx := newPaginationTestContext(t, NewMemoryStorage(), 0)
+	err := ctx.Database.View(func(tx Tx) error {
+		page, err := Paginate[paginationTestRecord](tx.Bucket([]byte("pagination_test")), PageRequest{})
+		if err != nil {
+			return err
+		}
+		if page.Items == nil || page.HasMore {
+			t.Fatalf("Empty bucket gave %+v", page)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Paginate: %v", err)
+	}
+}
+
+func TestIsPaginated(t *testing.T) {
+	type embedded struct {
+		PageRequest
+		Filter string
+	}
+	type plain struct {
+		Filter string
+	}
+	cases := []struct {
+		input, output reflect.Type
+		expected      bool
+	}{
+		{reflect.TypeOf(PageRequest{}), nil, true},
+		{reflect.TypeOf(embedded{}), nil, true},
+		{reflect.TypeOf(plain{}), nil, false},
+		{nil, reflect.TypeOf(PageResponse[plain]{}), true},
+		{reflect.TypeOf(plain{}), reflect.TypeOf(plain{}), false},
+	}
+	for _, c := range cases {
+		if isPaginated(c.input, c.output) != c.expected {
+			t.Fatalf("isPaginated(%v, %v) is not %v", c.input, c.output, c.expected)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package easyframework
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+/*
+Encoded password hashes look like this:
+	$ef1$argon2id$m=65536,t=3,p=4$<salt>$<hash>
+	$ef1$pbkdf2-sha256$i=600000$<salt>$<hash>
+"ef1" is the version of the format itself, salt and hash are unpadded base64.
+*/
+
+const PASSWORD_HASH_FORMAT_VERSION = "ef1"
+
+type PasswordAlgorithm string
+
+const (
+	PASSWORD_ALGORITHM_ARGON2ID      PasswordAlgorithm = "argon2id"
+	PASSWORD_ALGORITHM_PBKDF2_SHA256 PasswordAlgorithm = "pbkdf2-sha256"
+)
+
+type PasswordHashParams struct {
+	Algorithm  PasswordAlgorithm
+	Memory     uint32 // argon2id, in KiB
+	Time       uint32 // argon2id
+	Threads    uint8  // argon2id
+	Iterations int    // pbkdf2
+	SaltLength int
+	KeyLength  int
+}
+
+// PasswordHashing is used by HashPassword, hashes made with other parameters are reported as needing a rehash
+var PasswordHashing = PasswordHashParams{
+	Algorithm:  PASSWORD_ALGORITHM_ARGON2ID,
+	Memory:     64 * 1024,
+	Time:       3,
+	Threads:    4,
+	SaltLength: 16,
+	KeyLength:  32,
+}
+
+type InvalidPasswordHashError struct {
+	Reason string
+}
+
+func (v InvalidPasswordHashError) Error() string {
+	return fmt.Sprintf("Invalid password hash: %v", v.Reason)
+}
+
+func HashPassword(password string) (string, error) {
+	return HashPasswordWithParams(password, PasswordHashing)
+}
+
+func HashPasswordWithParams(password string, params PasswordHashParams) (string, error) {
+	salt := make([]byte, params.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := derivePasswordKey(password, salt, params)
+	if err != nil {
+		return "", err
+	}
+
+	return encodePasswordHash(params, salt, key), nil
+}
+
+// VerifyPassword compares the password against the encoded hash in constant time.
+// needsRehash is set when the hash was made with parameters different from PasswordHashing.
+func VerifyPassword(password, encoded string) (ok bool, needsRehash bool, err error) {
+	params, salt, key, err := decodePasswordHash(encoded)
+	if err != nil {
+		return false, false, err
+	}
+
+	candidate, err := derivePasswordKey(password, salt, params)
+	if err != nil {
+		return false, false, err
+	}
+
+	ok = subtle.ConstantTimeCompare(key, candidate) == 1
+	needsRehash = ok && params != PasswordHashing
+	return ok, needsRehash, nil
+}
+
+// VerifyAndRehashPassword verifies the password and replaces *encoded with a fresh hash if the parameters changed.
+// rehashed tells the caller that the record has to be saved.
+func VerifyAndRehashPassword(password string, encoded *string) (ok bool, rehashed bool, err error) {
+	ok, needsRehash, err := VerifyPassword(password, *encoded)
+	if err != nil || !ok || !needsRehash {
+		return ok, false, err
+	}
+
+	newHash, err := HashPassword(password)
+	if err != nil {
+		return ok, false, err
+	}
+	*encoded = newHash
+
+	return ok, true, nil
+}
+
+func derivePasswordKey(password string, salt []byte, params PasswordHashParams) ([]byte, error) {
+	switch params.Algorithm {
+	case PASSWORD_ALGORITHM_ARGON2ID:
+		return argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(params.KeyLength)), nil
+	case PASSWORD_ALGORITHM_PBKDF2_SHA256:
+		return pbkdf2.Key([]byte(password), salt, params.Iterations, params.KeyLength, sha256.New), nil
+	}
+
+	return nil, InvalidPasswordHashError{Reason: fmt.Sprintf("unknown algorithm %v", params.Algorithm)}
+}
+
+func encodePasswordHash(params PasswordHashParams, salt, key []byte) string {
+	settings := ""
+	switch params.Algorithm {
+	case PASSWORD_ALGORITHM_ARGON2ID:
+		settings = fmt.Sprintf("m=%v,t=%v,p=%v", params.Memory, params.Time, params.Threads)
+	case PASSWORD_ALGORITHM_PBKDF2_SHA256:
+		settings = fmt.Sprintf("i=%v", params.Iterations)
+	}
+
+	return fmt.Sprintf("$%v$%v$%v$%v$%v",
+		PASSWORD_HASH_FORMAT_VERSION,
+		params.Algorithm,
+		settings,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))
+}
+
+func decodePasswordHash(encoded string) (params PasswordHashParams, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" {
+		return params, nil, nil, InvalidPasswordHashError{Reason: "wrong number of parts"}
+	}
+	if parts[1] != PASSWORD_HASH_FORMAT_VERSION {
+		return params, nil, nil, InvalidPasswordHashError{Reason: fmt.Sprintf("unknown version %v", parts[1])}
+	}
+
+	params.Algorithm = PasswordAlgorithm(parts[2])
+	switch params.Algorithm {
+	case PASSWORD_ALGORITHM_ARGON2ID:
+		_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	case PASSWORD_ALGORITHM_PBKDF2_SHA256:
+		_, err = fmt.Sscanf(parts[3], "i=%d", &params.Iterations)
+	default:
+		err = errors.New("unknown algorithm")
+	}
+	if err != nil {
+		return params, nil, nil, InvalidPasswordHashError{Reason: err.Error()}
+	}
+
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, InvalidPasswordHashError{Reason: err.Error()}
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, InvalidPasswordHashError{Reason: err.Error()}
+	}
+	params.SaltLength = len(salt)
+	params.KeyLength = len(key)
+
+	return params, salt, key, nil
+}
+
+const REDACTED_PASSWORD = "[redacted]"
+
+// RedactPasswordsForLog replaces values of `tag:"password"` fields in JSON data of the given type, for logging
+func RedactPasswordsForLog(typeof reflect.Type, data []byte) string {
+	return string(redactPasswords(typeof, reflect.Value{}, data, func(object map[string]interface{}, key string) {
+		object[key] = REDACTED_PASSWORD
+	}))
+}
+
+// RemovePasswords drops `tag:"password"` fields from JSON data marshalled from value, so they are never sent back.
+// The value is used to look into interface{} fields, typeof alone is enough otherwise.
+func RemovePasswords(typeof reflect.Type, value reflect.Value, data []byte) []byte {
+	return redactPasswords(typeof, value, data, func(object map[string]interface{}, key string) {
+		delete(object, key)
+	})
+}
+
+func redactPasswords(typeof reflect.Type, value reflect.Value, data []byte, redact func(object map[string]interface{}, key string)) []byte {
+	if typeof == nil || !MayHavePasswordFields(typeof) {
+		return data
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var decoded interface{}
+	err := decoder.Decode(&decoded)
+	if err != nil { // Not valid json, nothing we can reliably redact
+		return []byte(REDACTED_PASSWORD)
+	}
+
+	redactPasswordValue(typeof, value, decoded, redact)
+
+	result, err := json.Marshal(decoded)
+	if err != nil {
+		return []byte(REDACTED_PASSWORD)
+	}
+	return result
+}
+
+// redactPasswordValue walks the decoded json guided by the go type. value is optional and walked alongside when valid.
+func redactPasswordValue(typeof reflect.Type, value reflect.Value, decoded interface{}, redact func(object map[string]interface{}, key string)) {
+	switch typeof.Kind() {
+	case reflect.Interface:
+		if value.IsValid() && !value.IsNil() {
+			redactPasswordValue(value.Elem().Type(), value.Elem(), decoded, redact)
+		}
+	case reflect.Pointer:
+		if value.IsValid() {
+			if value.IsNil() {
+				return
+			}
+			value = value.Elem()
+		}
+		redactPasswordValue(typeof.Elem(), value, decoded, redact)
+	case reflect.Slice, reflect.Array:
+		array, ok := decoded.([]interface{})
+		if !ok {
+			return
+		}
+		for i, element := range array {
+			var elementValue reflect.Value
+			if value.IsValid() && i < value.Len() {
+				elementValue = value.Index(i)
+			}
+			redactPasswordValue(typeof.Elem(), elementValue, element, redact)
+		}
+	case reflect.Map:
+		object, ok := decoded.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for key, element := range object {
+			var elementValue reflect.Value
+			if value.IsValid() && typeof.Key().Kind() == reflect.String {
+				elementValue = value.MapIndex(reflect.ValueOf(key).Convert(typeof.Key()))
+			}
+			redactPasswordValue(typeof.Elem(), elementValue, element, redact)
+		}
+	case reflect.Struct:
+		object, ok := decoded.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for i := 0; i < typeof.NumField(); i += 1 {
+			field := typeof.Field(i)
+			var fieldValue reflect.Value
+			if value.IsValid() {
+				fieldValue = value.Field(i)
+			}
+
+			name := JsonFieldName(field)
+			if name == "-" {
+				continue
+			}
+			if field.Anonymous && name == field.Name { // json flattens embedded structs into the parent
+				redactPasswordValue(field.Type, fieldValue, object, redact)
+				continue
+			}
+
+			for key := range object { // json matches keys to fields ignoring case, every spelling ends up in the field
+				if !strings.EqualFold(key, name) {
+					continue
+				}
+				if ParseOurTags(field).IsAPassword {
+					redact(object, key)
+					continue
+				}
+				redactPasswordValue(field.Type, fieldValue, object[key], redact)
+			}
+		}
+	}
+}
+
+func JsonFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		name = field.Name
+	}
+	return name
+}
+
+// MayHavePasswordFields is true if the type has password fields, or interface{} fields that could hold them
+func MayHavePasswordFields(typeof reflect.Type) bool {
+	return mayHavePasswordFields(typeof, make(map[reflect.Type]bool))
+}
+
+func mayHavePasswordFields(typeof reflect.Type, visited map[reflect.Type]bool) bool {
+	if visited[typeof] {
+		return false
+	}
+	visited[typeof] = true
+
+	switch typeof.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		return mayHavePasswordFields(typeof.Elem(), visited)
+	case reflect.Struct:
+		for i := 0; i < typeof.NumField(); i += 1 {
+			field := typeof.Field(i)
+			if ParseOurTags(field).IsAPassword || mayHavePasswordFields(field.Type, visited) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
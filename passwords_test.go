@@ -0,0 +1,80 @@
+package easyframework
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type passwordTestLogin struct {
+	Username string
+	Password string `tag:"password"`
+}
+
+type passwordTestNested struct {
+	Login  passwordTestLogin `json:"login"`
+	Logins []passwordTestLogin
+	Extra  interface{}
+}
+
+func TestHashAndVerifyPassword(t *testing.T) {
+	encoded, err := HashPassword("hunter2")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	if strings.Contains(encoded, "hunter2") {
+		t.Fatalf("Hash contains the password")
+	}
+
+	ok, _, err := VerifyPassword("hunter2", encoded)
+	if err != nil || !ok {
+		t.Fatalf("Right password was rejected: %v %v", ok, err)
+	}
+	ok, _, err = VerifyPassword("hunter3", encoded)
+	if err != nil || ok {
+		t.Fatalf("Wrong password was accepted: %v %v", ok, err)
+	}
+}
+
+func TestRedactPasswordsForLogIgnoresCase(t *testing.T) {
+	typeof := reflect.TypeOf(passwordTestLogin{})
+	for _, data := range []string{
+		`{"Username":"alice","Password":"hunter2"}`,
+		`{"username":"alice","password":"hunter2"}`,
+		`{"username":"alice","PASSWORD":"hunter2","pAsSwOrD":"hunter2"}`,
+	} {
+		var decoded passwordTestLogin
+		err := json.Unmarshal([]byte(data), &decoded)
+		if err != nil || decoded.Password != "hunter2" {
+			t.Fatalf("%v doesn't decode into the password field: %v", data, err)
+		}
+
+		redacted := RedactPasswordsForLog(typeof, []byte(data))
+		if strings.Contains(redacted, "hunter2") {
+			t.Fatalf("Password of %v is in the log: %v", data, redacted)
+		}
+		if !strings.Contains(redacted, "alice") {
+			t.Fatalf("Other fields of %v were lost: %v", data, redacted)
+		}
+	}
+}
+
+func TestRedactPasswordsNested(t *testing.T) {
+	typeof := reflect.TypeOf(passwordTestNested{})
+	data := `{"LOGIN":{"password":"hunter2"},"logins":[{"Password":"hunter2"}],"extra":"hunter2 is fine here"}`
+	redacted := RedactPasswordsForLog(typeof, []byte(data))
+	if strings.Count(redacted, "hunter2") != 1 {
+		t.Fatalf("Expected only the untagged field to keep the value: %v", redacted)
+	}
+
+	value := passwordTestNested{Login: passwordTestLogin{Username: "alice", Password: "hunter2"}}
+	marshalled, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed := string(RemovePasswords(typeof, reflect.ValueOf(value), marshalled))
+	if strings.Contains(removed, "hunter2") || strings.Contains(removed, "Password") {
+		t.Fatalf("Password was sent back: %v", removed)
+	}
+}
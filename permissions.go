@@ -0,0 +1,106 @@
+package easyframework
+
+import (
+	"log"
+)
+
+const PERMISSION_ALL = "*"
+
+// Principal is what the PermissionResolver knows about the caller
+type Principal struct {
+	Roles       []string
+	Permissions []string
+}
+
+// PermissionResolver maps the authenticated caller to roles and permissions. It runs after Authorization, so fields like UserID are already filled.
+// For API key callers UserID is the key owner, the key gets the scopes the owner still has.
+type PermissionResolver func(requestContext *RequestContext) (Principal, error)
+
+// HasPermission checks the principal's own permissions and the permissions of its roles from ctx.RolePermissions
+func HasPermission(ctx *Context, principal *Principal, permission string) bool {
+	for _, granted := range principal.Permissions {
+		if granted == permission || granted == PERMISSION_ALL {
+			return true
+		}
+	}
+
+	for _, role := range principal.Roles {
+		for _, granted := range ctx.RolePermissions[role] {
+			if granted == permission || granted == PERMISSION_ALL {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// CheckProcedurePermissions resolves the principal and checks that it has every permission the procedure requires
+func CheckProcedurePermissions(ctx *Context, requestContext *RequestContext) (missing string, allowed bool) {
+	procedure := requestContext.Procedure
+	if len(procedure.Permissions) == 0 {
+		return "", true
+	}
+
+	if requestContext.APIKey != nil {
+		principal, err := apiKeyPrincipal(ctx, requestContext)
+		if err != nil {
+			log.Printf("PermissionResolver failed for the owner of API key %v: %v", requestContext.APIKey.ID, err)
+			return procedure.Permissions[0], false
+		}
+		requestContext.Principal = &principal
+
+		for _, permission := range procedure.Permissions {
+			if !HasPermission(ctx, &principal, permission) {
+				return permission, false
+			}
+		}
+
+		return "", true
+	}
+
+	if ctx.PermissionResolver == nil {
+		log.Printf("%v requires permissions, but there is no PermissionResolver", procedure.Identifier)
+		return procedure.Permissions[0], false
+	}
+
+	principal, err := ctx.PermissionResolver(requestContext)
+	if err != nil {
+		log.Printf("PermissionResolver failed: %v", err)
+		return procedure.Permissions[0], false
+	}
+	requestContext.Principal = &principal
+
+	for _, permission := range procedure.Permissions {
+		if !HasPermission(ctx, &principal, permission) {
+			return permission, false
+		}
+	}
+
+	return "", true
+}
+
+// apiKeyPrincipal is what both the key scopes and the owner's current permissions grant, so a demoted owner's keys
+// lose access too. Without a PermissionResolver the scopes alone decide.
+func apiKeyPrincipal(ctx *Context, requestContext *RequestContext) (Principal, error) {
+	scopes := requestContext.APIKey.Scopes
+	if ctx.PermissionResolver == nil {
+		return Principal{Permissions: scopes}, nil
+	}
+
+	owner, err := ctx.PermissionResolver(requestContext) // UserID is the owner, see APIKeyAuthorization
+	if err != nil {
+		return Principal{}, err
+	}
+
+	var principal Principal
+	for _, scope := range scopes {
+		if scope == PERMISSION_ALL { // The key grants whatever the owner has
+			return owner, nil
+		}
+		if HasPermission(ctx, &owner, scope) {
+			principal.Permissions = append(principal.Permissions, scope)
+		}
+	}
+	return principal, nil
+}
@@ -1,52 +1,168 @@
 package easyframework
 
 import (
+	"encoding/binary"
+	"log"
 	"net"
 	"net/http"
 	"sync"
 	"time"
 )
 
+const BUCKET_RATE_LIMITS BucketID = "ef_rate_limits"
+
+const RateLimiterWindow = time.Minute
+
+// RateLimiterStorage keeps request counters per host and per window. Several Contexts can share one storage to share the limits.
+type RateLimiterStorage interface {
+	// Increment bumps the counter for the host in the given window and returns the new value
+	Increment(host string, window int64) (int, error)
+	// Compact removes counters of all windows older than the given one
+	Compact(before int64) error
+}
+
 type RateLimiter struct {
-	Mutex                sync.Mutex
-	UserRequestsCount    *map[string]int
+	Storage              RateLimiterStorage
 	MaxRequestsPerMinute int
 }
 
+func CurrentRateLimiterWindow() int64 {
+	return time.Now().Unix() / int64(RateLimiterWindow/time.Second)
+}
+
 func ShouldRequestBeRateLimited(context *Context, w http.ResponseWriter, r *http.Request) (requestCount int, shouldBeRateLimited bool) {
 	rateLimiter := &context.RateLimiter
 
-	rateLimiter.Mutex.Lock()
-	defer rateLimiter.Mutex.Unlock()
-
 	host, _, _ := net.SplitHostPort(r.RemoteAddr)
-	requests, ok := (*rateLimiter.UserRequestsCount)[host]
-	requestCount = requests
-	if !ok {
-		(*rateLimiter.UserRequestsCount)[host] = 1
+	requestCount, err := rateLimiter.Storage.Increment(host, CurrentRateLimiterWindow())
+	if err != nil { // We'd rather let the request through than fail it because of the limiter
+		log.Printf("Rate limiter storage failed: %v", err)
 		return
 	}
-	if requests > rateLimiter.MaxRequestsPerMinute {
+	if requestCount > rateLimiter.MaxRequestsPerMinute {
 		shouldBeRateLimited = true
 		w.WriteHeader(http.StatusTooManyRequests)
 		return
 	}
-	(*rateLimiter.UserRequestsCount)[host] += 1
 
 	return
 }
 
 func RateLimiterRoutine(context *Context) {
 	rateLimiter := &context.RateLimiter
-	period := time.Minute
 	for {
-		time.Sleep(period)
+		time.Sleep(RateLimiterWindow)
+
+		err := rateLimiter.Storage.Compact(CurrentRateLimiterWindow())
+		if err != nil {
+			log.Printf("Rate limiter compaction failed: %v", err)
+		}
+	}
+}
+
+// MemoryRateLimiterStorage is the default storage, the state is lost on restart
+type MemoryRateLimiterStorage struct {
+	Mutex             sync.Mutex
+	Window            int64
+	UserRequestsCount map[string]int
+}
+
+func NewMemoryRateLimiterStorage() *MemoryRateLimiterStorage {
+	return &MemoryRateLimiterStorage{
+		UserRequestsCount: make(map[string]int),
+	}
+}
+
+func (storage *MemoryRateLimiterStorage) Increment(host string, window int64) (int, error) {
+	storage.Mutex.Lock()
+	defer storage.Mutex.Unlock()
+
+	if window != storage.Window {
+		storage.Window = window
+		storage.UserRequestsCount = make(map[string]int)
+	}
+	storage.UserRequestsCount[host] += 1
+
+	return storage.UserRequestsCount[host], nil
+}
+
+func (storage *MemoryRateLimiterStorage) Compact(before int64) error {
+	storage.Mutex.Lock()
+	defer storage.Mutex.Unlock()
 
-		rateLimiter.Mutex.Lock()
+	if storage.Window < before {
+		storage.UserRequestsCount = make(map[string]int)
+	}
+
+	return nil
+}
 
-		requestsPerUser := make(map[string]int)
-		rateLimiter.UserRequestsCount = &requestsPerUser
+// BoltRateLimiterStorage keeps counters in the database so they survive restarts.
+// Keys are (window, host) with the window encoded big endian, so expired windows are always at the start of the bucket.
+type BoltRateLimiterStorage struct {
+	Database Storage
+}
 
-		rateLimiter.Mutex.Unlock()
+func NewBoltRateLimiterStorage(ctx *Context) (*BoltRateLimiterStorage, error) {
+	err := NewBucket(ctx, BUCKET_RATE_LIMITS)
+	if err != nil {
+		return nil, err
 	}
+
+	return &BoltRateLimiterStorage{
+		Database: ctx.Database,
+	}, nil
+}
+
+func rateLimiterKey(host string, window int64) []byte {
+	key := make([]byte, 8+len(host))
+	binary.BigEndian.PutUint64(key, uint64(window))
+	copy(key[8:], host)
+	return key
+}
+
+func (storage *BoltRateLimiterStorage) Increment(host string, window int64) (int, error) {
+	var count uint32
+	err := storage.Database.Batch(func(tx Tx) error {
+		bucket, err := GetBucket(tx, BUCKET_RATE_LIMITS)
+		if err != nil {
+			return err
+		}
+
+		key := rateLimiterKey(host, window)
+		count = 0
+		if value := bucket.Get(key); len(value) == 4 {
+			count = binary.BigEndian.Uint32(value)
+		}
+		count += 1
+
+		var value [4]byte
+		binary.BigEndian.PutUint32(value[:], count)
+		return bucket.Put(key, value[:])
+	})
+
+	return int(count), err
+}
+
+func (storage *BoltRateLimiterStorage) Compact(before int64) error {
+	return storage.Database.Update(func(tx Tx) error {
+		bucket, err := GetBucket(tx, BUCKET_RATE_LIMITS)
+		if err != nil {
+			return err
+		}
+
+		cursor := bucket.Cursor()
+		for key, _ := cursor.First(); key != nil; key, _ = cursor.First() {
+			if len(key) >= 8 && int64(binary.BigEndian.Uint64(key)) >= before {
+				break
+			}
+
+			err := cursor.Delete()
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
 }
@@ -0,0 +1,299 @@
+package easyframework
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const BUCKET_SESSIONS BucketID = "ef_sessions"
+
+type Session struct {
+	ID         ID[Session] `id:"1"`
+	UserID     ID[User]    `id:"2"`
+	CreatedAt  int64       `id:"3"`
+	ExpiresAt  int64       `id:"4"`
+	LastSeenAt int64       `id:"5"`
+	IP         string      `id:"6"`
+}
+
+type SessionParams struct {
+	Lifetime        time.Duration // Default is 24 hours
+	Sliding         bool          // Every use of the session moves its expiry Lifetime into the future
+	CookieName      string        // Default is "session"
+	InsecureCookies bool          // Drop the Secure attribute, only for local development over plain http
+	SameSite        http.SameSite // Default is http.SameSiteLaxMode
+	BindIP          bool          // Session is only valid from the IP it was created from
+}
+
+type SessionNotFoundError struct{}
+
+func (v SessionNotFoundError) Error() string {
+	return "Session not found"
+}
+
+type SessionExpiredError struct{}
+
+func (v SessionExpiredError) Error() string {
+	return "Session expired"
+}
+
+type SessionIPMismatchError struct{}
+
+func (v SessionIPMismatchError) Error() string {
+	return "Session was created from a different IP"
+}
+
+func InitializeSessions(ctx *Context, params SessionParams) error {
+	if params.Lifetime == 0 {
+		params.Lifetime = time.Hour * 24
+	}
+	if params.CookieName == "" {
+		params.CookieName = "session"
+	}
+	if params.SameSite == 0 {
+		params.SameSite = http.SameSiteLaxMode
+	}
+
+	err := NewBucket(ctx, BUCKET_SESSIONS)
+	if err != nil {
+		return err
+	}
+
+	ctx.Sessions = &params // Expired sessions are removed by the expiry sweeper
+
+	return nil
+}
+
+func NewSession(ctx *Context, userID ID[User], ip string) (Session, error) {
+	now := time.Now()
+	session := Session{
+		ID:         NewID[Session](),
+		UserID:     userID,
+		CreatedAt:  now.Unix(),
+		ExpiresAt:  now.Add(ctx.Sessions.Lifetime).Unix(),
+		LastSeenAt: now.Unix(),
+		IP:         ip,
+	}
+
+	err := ctx.Database.Update(func(tx Tx) error {
+		return InsertWithExpiry(tx, BUCKET_SESSIONS, session.ID.Raw(), &session, time.Unix(session.ExpiresAt, 0))
+	})
+	return session, err
+}
+
+// LookupSession returns a session that exists and has not expired. If ip is not empty and sessions are bound to IP, it is checked as well.
+func LookupSession(ctx *Context, sessionID ID[Session], ip string) (Session, error) {
+	var session Session
+	if !GetByID(ctx, BUCKET_SESSIONS, sessionID.Raw(), &session) {
+		return session, SessionNotFoundError{}
+	}
+
+	if session.ExpiresAt <= time.Now().Unix() {
+		return session, SessionExpiredError{}
+	}
+
+	if ctx.Sessions.BindIP && ip != "" && session.IP != ip {
+		return session, SessionIPMismatchError{}
+	}
+
+	return session, nil
+}
+
+// RefreshSession moves the expiry of the session Lifetime into the future
+func RefreshSession(ctx *Context, sessionID ID[Session]) (Session, error) {
+	var session Session
+	err := ctx.Database.Update(func(tx Tx) error {
+		bucket, err := GetBucket(tx, BUCKET_SESSIONS)
+		if err != nil {
+			return err
+		}
+
+		data := bucket.Get(sessionID[:])
+		if data == nil {
+			return SessionNotFoundError{}
+		}
+		err = Unpack(data, &session)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if session.ExpiresAt <= now.Unix() {
+			return SessionExpiredError{}
+		}
+		session.LastSeenAt = now.Unix()
+		session.ExpiresAt = now.Add(ctx.Sessions.Lifetime).Unix()
+
+		return InsertWithExpiry(tx, BUCKET_SESSIONS, sessionID.Raw(), &session, time.Unix(session.ExpiresAt, 0))
+	})
+
+	return session, err
+}
+
+func RevokeSession(ctx *Context, sessionID ID[Session]) error {
+	return ctx.Database.Update(func(tx Tx) error {
+		bucket, err := GetBucket(tx, BUCKET_SESSIONS)
+		if err != nil {
+			return err
+		}
+
+		return Delete[Session](bucket, sessionID.Raw())
+	})
+}
+
+// RevokeUserSessions removes every session of the user, returns how many were removed
+func RevokeUserSessions(ctx *Context, userID ID[User]) (int, error) {
+	return removeSessions(ctx, func(session *Session) bool {
+		return session.UserID == userID
+	})
+}
+
+func removeSessions(ctx *Context, condition func(session *Session) bool) (int, error) {
+	removed := 0
+	err := ctx.Database.Update(func(tx Tx) error {
+		bucket, err := GetBucket(tx, BUCKET_SESSIONS)
+		if err != nil {
+			return err
+		}
+
+		var keys []ID128 // Deleting under a cursor skips elements, so collect first
+		Iterate(bucket, func(key ID128, session *Session) bool {
+			if condition(session) {
+				keys = append(keys, key)
+			}
+			return true
+		})
+
+		for _, key := range keys {
+			err := Delete[Session](bucket, key)
+			if err != nil {
+				return err
+			}
+		}
+		removed = len(keys)
+
+		return nil
+	})
+
+	return removed, err
+}
+
+// SessionTokenFromRequest takes the token from the Authorization: Bearer header, or from the session cookie
+func SessionTokenFromRequest(ctx *Context, r *http.Request) string {
+	token, _ := sessionTokenFromRequest(ctx, r)
+	return token
+}
+
+func sessionTokenFromRequest(ctx *Context, r *http.Request) (string, CredentialSource) {
+	authorization := r.Header.Get("Authorization")
+	if strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimSpace(authorization[len("Bearer "):]), CREDENTIAL_HEADER
+	}
+
+	cookie, err := r.Cookie(ctx.Sessions.CookieName)
+	if err != nil {
+		return "", CREDENTIAL_UNKNOWN
+	}
+
+	return cookie.Value, CREDENTIAL_COOKIE
+}
+
+func SetSessionCookie(ctx *Context, w http.ResponseWriter, session Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     ctx.Sessions.CookieName,
+		Value:    session.ID.String(),
+		Path:     "/",
+		Expires:  time.Unix(session.ExpiresAt, 0),
+		HttpOnly: true,
+		Secure:   !ctx.Sessions.InsecureCookies,
+		SameSite: ctx.Sessions.SameSite,
+	})
+}
+
+func ClearSessionCookie(ctx *Context, w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     ctx.Sessions.CookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   !ctx.Sessions.InsecureCookies,
+		SameSite: ctx.Sessions.SameSite,
+	})
+}
+
+// StartSession creates a session for the user, sets the cookie and fills the request context. The token can be returned to bearer clients as well.
+func StartSession(requestContext *RequestContext, userID ID[User]) (Session, error) {
+	ctx := requestContext.Context
+	ip, _, _ := net.SplitHostPort(requestContext.Request.RemoteAddr)
+
+	session, err := NewSession(ctx, userID, ip)
+	if err != nil {
+		return session, err
+	}
+
+	SetSessionCookie(ctx, requestContext.ResponseWriter, session)
+	requestContext.Session = &session
+	requestContext.SessionToken = session.ID.String()
+	requestContext.UserID = userID
+
+	return session, nil
+}
+
+// EndSession revokes the session of the current request and clears the cookie
+func EndSession(requestContext *RequestContext) error {
+	ctx := requestContext.Context
+	ClearSessionCookie(ctx, requestContext.ResponseWriter)
+	if requestContext.Session == nil {
+		return nil
+	}
+
+	err := RevokeSession(ctx, requestContext.Session.ID)
+	requestContext.Session = nil
+	requestContext.SessionToken = ""
+	return err
+}
+
+// SessionAuthorization is the Authorization procedure used when sessions are enabled and no custom one is given
+func SessionAuthorization(requestContext *RequestContext, w http.ResponseWriter, r *http.Request) bool {
+	ctx := requestContext.Context
+
+	token, source := sessionTokenFromRequest(ctx, r)
+	if token == "" {
+		return false
+	}
+
+	var sessionID ID[Session]
+	err := (*ID128)(&sessionID).FromString(token)
+	if err != nil {
+		return false
+	}
+
+	ip, _, _ := net.SplitHostPort(r.RemoteAddr)
+	session, err := LookupSession(ctx, sessionID, ip)
+	if err != nil {
+		return false
+	}
+
+	if ctx.Sessions.Sliding && time.Now().Unix()-session.LastSeenAt >= 60 { // Don't write on every single request
+		session, err = RefreshSession(ctx, sessionID)
+		if err != nil {
+			log.Printf("Failed to refresh session: %v", err)
+			return false
+		}
+
+		if source == CREDENTIAL_COOKIE {
+			SetSessionCookie(ctx, w, session)
+		}
+	}
+
+	requestContext.Session = &session
+	requestContext.SessionToken = token
+	requestContext.UserID = session.UserID
+	requestContext.Credential = source
+
+	return true
+}
@@ -0,0 +1,47 @@
+package easyframework
+
+import "testing"
+
+func TestRevokeSessionClearsExpiry(t *testing.T) {
+	ctx := new(Context)
+	err := Initialize(ctx, InitializeParams{Storage: NewMemoryStorage(), Sessions: &SessionParams{}})
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+
+	userID := NewID[User]()
+	first, err := NewSession(ctx, userID, "")
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	second, err := NewSession(ctx, userID, "")
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+
+	err = RevokeSession(ctx, first.ID)
+	if err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	removed, err := RevokeUserSessions(ctx, userID)
+	if err != nil || removed != 1 {
+		t.Fatalf("RevokeUserSessions removed %v: %v", removed, err)
+	}
+
+	ctx.Database.View(func(tx Tx) error {
+		for _, session := range []Session{first, second} {
+			if _, ok := GetExpiry(tx.Bucket([]byte(BUCKET_SESSIONS)), session.ID.Raw()); ok {
+				t.Fatalf("Expiry of a revoked session is left behind")
+			}
+		}
+		if key, _ := tx.Bucket([]byte(BUCKET_EXPIRY)).Cursor().First(); key != nil {
+			t.Fatalf("Expiry index still has an entry of a revoked session")
+		}
+		return nil
+	})
+
+	_, err = LookupSession(ctx, second.ID, "")
+	if _, ok := err.(SessionNotFoundError); !ok {
+		t.Fatalf("Revoked session was found: %v", err)
+	}
+}
@@ -0,0 +1,263 @@
+package easyframework
+
+import (
+	"io"
+	"os"
+
+	"github.com/boltdb/bolt"
+)
+
+/*
+Storage is the key-value database under everything in the framework. It is shaped after bolt, which is the default
+backend (BoltStorage); MemoryStorage keeps everything in memory, for tests and examples.
+
+Semantics every backend has to follow, the rest of the framework relies on them:
+  - one write transaction at a time, read transactions see the state as of their start
+  - buckets nest, a nested bucket shows up in the cursor of its parent as a key with a nil value
+  - keys are ordered bytewise
+  - slices returned by Get and cursors are only valid until the transaction ends and must not be modified
+  - OnCommit procedures run after a successful commit of a write transaction
+*/
+type Storage interface {
+	Begin(writable bool) (Tx, error)
+	View(procedure func(tx Tx) error) error   // Read transaction, always rolled back
+	Update(procedure func(tx Tx) error) error // Write transaction, committed if the procedure returns nil
+	Batch(procedure func(tx Tx) error) error  // Update that may be merged with concurrent ones, the procedure may run more than once
+	Close() error
+}
+
+type Tx interface {
+	Bucket(name []byte) Bucket // nil if there is no such bucket
+	CreateBucket(name []byte) (Bucket, error)
+	CreateBucketIfNotExists(name []byte) (Bucket, error)
+	DeleteBucket(name []byte) error
+	ForEach(procedure func(name []byte, bucket Bucket) error) error // Top level buckets
+	Writable() bool
+	OnCommit(procedure func())
+	Commit() error
+	Rollback() error
+}
+
+type Bucket interface {
+	Name() []byte
+	Parent() Bucket // nil for top level buckets
+	Tx() Tx
+	Writable() bool
+	Get(key []byte) []byte
+	Put(key []byte, value []byte) error
+	Delete(key []byte) error
+	Cursor() Cursor
+	ForEach(procedure func(key, value []byte) error) error
+	NextSequence() (uint64, error)
+	Bucket(name []byte) Bucket // nil if there is no such bucket
+	CreateBucket(name []byte) (Bucket, error)
+	CreateBucketIfNotExists(name []byte) (Bucket, error)
+	DeleteBucket(name []byte) error
+}
+
+type Cursor interface {
+	First() (key []byte, value []byte)
+	Last() (key []byte, value []byte)
+	Next() (key []byte, value []byte)
+	Prev() (key []byte, value []byte)
+	Seek(seek []byte) (key []byte, value []byte) // First key >= seek
+	Delete() error                               // Removes the key the cursor is at
+}
+
+// SnapshotTx is a transaction that can write a copy of the whole database, Backup needs it
+type SnapshotTx interface {
+	Size() int64
+	WriteTo(w io.Writer) (int64, error)
+}
+
+type StorageNotSupportedError struct {
+	Feature string
+}
+
+func (v StorageNotSupportedError) Error() string {
+	return "Storage doesn't support " + v.Feature
+}
+
+// BoltStorage is Storage on top of a bolt file
+type BoltStorage struct {
+	DB *bolt.DB
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+type boltBucket struct {
+	bucket *bolt.Bucket
+	name   []byte
+	parent *boltBucket // nil for top level buckets
+	tx     *boltTx
+}
+
+func OpenBoltStorage(path string, mode os.FileMode, options *bolt.Options) (*BoltStorage, error) {
+	database, err := bolt.Open(path, mode, options)
+	if err != nil {
+		return nil, err
+	}
+	return &BoltStorage{DB: database}, nil
+}
+
+func (storage *BoltStorage) Begin(writable bool) (Tx, error) {
+	if writable {
+		err := checkNestedWrite()
+		if err != nil {
+			return nil, err
+		}
+	}
+	tx, err := storage.DB.Begin(writable)
+	if err != nil {
+		return nil, err
+	}
+	return &boltTx{tx: tx}, nil
+}
+
+func (storage *BoltStorage) View(procedure func(tx Tx) error) error {
+	return storage.DB.View(func(tx *bolt.Tx) error {
+		return procedure(&boltTx{tx: tx})
+	})
+}
+
+func (storage *BoltStorage) Update(procedure func(tx Tx) error) error {
+	err := checkNestedWrite()
+	if err != nil {
+		return err
+	}
+	return storage.DB.Update(func(tx *bolt.Tx) error {
+		return procedure(&boltTx{tx: tx})
+	})
+}
+
+func (storage *BoltStorage) Batch(procedure func(tx Tx) error) error {
+	err := checkNestedWrite()
+	if err != nil {
+		return err
+	}
+	return storage.DB.Batch(func(tx *bolt.Tx) error {
+		return procedure(&boltTx{tx: tx})
+	})
+}
+
+func (storage *BoltStorage) Close() error {
+	return storage.DB.Close()
+}
+
+func (tx *boltTx) wrap(bucket *bolt.Bucket, name []byte, parent *boltBucket) Bucket {
+	if bucket == nil {
+		return nil // Not a nil *boltBucket, that would not compare equal to nil
+	}
+	return &boltBucket{bucket: bucket, name: append([]byte(nil), name...), parent: parent, tx: tx}
+}
+
+func (tx *boltTx) Bucket(name []byte) Bucket {
+	return tx.wrap(tx.tx.Bucket(name), name, nil)
+}
+
+func (tx *boltTx) CreateBucket(name []byte) (Bucket, error) {
+	bucket, err := tx.tx.CreateBucket(name)
+	return tx.wrap(bucket, name, nil), err
+}
+
+func (tx *boltTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	bucket, err := tx.tx.CreateBucketIfNotExists(name)
+	return tx.wrap(bucket, name, nil), err
+}
+
+func (tx *boltTx) DeleteBucket(name []byte) error {
+	return tx.tx.DeleteBucket(name)
+}
+
+func (tx *boltTx) ForEach(procedure func(name []byte, bucket Bucket) error) error {
+	return tx.tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+		return procedure(name, tx.wrap(bucket, name, nil))
+	})
+}
+
+func (tx *boltTx) Writable() bool {
+	return tx.tx.Writable()
+}
+
+func (tx *boltTx) OnCommit(procedure func()) {
+	tx.tx.OnCommit(procedure)
+}
+
+func (tx *boltTx) Commit() error {
+	return tx.tx.Commit()
+}
+
+func (tx *boltTx) Rollback() error {
+	return tx.tx.Rollback()
+}
+
+func (tx *boltTx) Size() int64 {
+	return tx.tx.Size()
+}
+
+func (tx *boltTx) WriteTo(w io.Writer) (int64, error) {
+	return tx.tx.WriteTo(w)
+}
+
+func (bucket *boltBucket) Name() []byte {
+	return bucket.name
+}
+
+func (bucket *boltBucket) Parent() Bucket {
+	if bucket.parent == nil {
+		return nil
+	}
+	return bucket.parent
+}
+
+func (bucket *boltBucket) Tx() Tx {
+	return bucket.tx
+}
+
+func (bucket *boltBucket) Writable() bool {
+	return bucket.bucket.Writable()
+}
+
+func (bucket *boltBucket) Get(key []byte) []byte {
+	return bucket.bucket.Get(key)
+}
+
+func (bucket *boltBucket) Put(key []byte, value []byte) error {
+	return bucket.bucket.Put(key, value)
+}
+
+func (bucket *boltBucket) Delete(key []byte) error {
+	return bucket.bucket.Delete(key)
+}
+
+func (bucket *boltBucket) Cursor() Cursor {
+	return bucket.bucket.Cursor()
+}
+
+func (bucket *boltBucket) ForEach(procedure func(key, value []byte) error) error {
+	return bucket.bucket.ForEach(procedure)
+}
+
+func (bucket *boltBucket) NextSequence() (uint64, error) {
+	return bucket.bucket.NextSequence()
+}
+
+func (bucket *boltBucket) Bucket(name []byte) Bucket {
+	return bucket.tx.wrap(bucket.bucket.Bucket(name), name, bucket)
+}
+
+func (bucket *boltBucket) CreateBucket(name []byte) (Bucket, error) {
+	nested, err := bucket.bucket.CreateBucket(name)
+	return bucket.tx.wrap(nested, name, bucket), err
+}
+
+func (bucket *boltBucket) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	nested, err := bucket.bucket.CreateBucketIfNotExists(name)
+	return bucket.tx.wrap(nested, name, bucket), err
+}
+
+func (bucket *boltBucket) DeleteBucket(name []byte) error {
+	return bucket.bucket.DeleteBucket(name)
+}
@@ -0,0 +1,563 @@
+package easyframework
+
+import (
+	"sort"
+	"sync"
+)
+
+/*
+MemoryStorage keeps the database in memory, nothing survives Close. Use it for tests and examples:
+
+	ef.Initialize(ef.InitializeParams{Storage: ef.NewMemoryStorage(), ...})
+
+Committed buckets are never modified. A write transaction copies a bucket, and every bucket above it, the first time
+it writes into it, commit swaps the root. Read transactions keep the root they started with, so they see a consistent
+snapshot and never wait for the writer.
+*/
+type MemoryStorage struct {
+	writer    sync.Mutex // Held by the write transaction for its whole life
+	rootMutex sync.RWMutex
+	root      *memoryBucket
+	closed    bool
+}
+
+type memoryBucket struct {
+	owner    *memoryTx // Write transaction that made this copy, only it modifies it and only until it ends
+	values   map[string][]byte
+	buckets  map[string]*memoryBucket
+	sequence uint64
+
+	keysMutex sync.Mutex // Readers of a committed bucket build keys concurrently
+	keys      []string   // Sorted keys of values and buckets, rebuilt lazily
+}
+
+type memoryTx struct {
+	storage  *MemoryStorage
+	root     *memoryBucket
+	writable bool
+	closed   bool
+	onCommit []func()
+}
+
+// memoryBucketHandle finds its bucket from the root on every access, copies made by writes are picked up that way
+type memoryBucketHandle struct {
+	tx     *memoryTx
+	parent *memoryBucketHandle // nil for top level buckets
+	name   string
+}
+
+type memoryCursor struct {
+	bucket *memoryBucketHandle
+	key    string
+	valid  bool
+}
+
+type TxClosedError struct{}
+
+func (v TxClosedError) Error() string {
+	return "Transaction is closed"
+}
+
+type TxNotWritableError struct{}
+
+func (v TxNotWritableError) Error() string {
+	return "Transaction is not writable"
+}
+
+type BucketExistsError struct {
+	Name string
+}
+
+func (v BucketExistsError) Error() string {
+	return "Bucket " + v.Name + " already exists"
+}
+
+type IncompatibleValueError struct {
+	Key string
+}
+
+func (v IncompatibleValueError) Error() string {
+	return "Key " + v.Key + " is a bucket and a value at the same time"
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{root: newMemoryBucket(nil)}
+}
+
+func newMemoryBucket(owner *memoryTx) *memoryBucket {
+	return &memoryBucket{
+		owner:   owner,
+		values:  make(map[string][]byte),
+		buckets: make(map[string]*memoryBucket),
+	}
+}
+
+func (storage *MemoryStorage) Begin(writable bool) (Tx, error) {
+	if writable {
+		err := checkNestedWrite()
+		if err != nil {
+			return nil, err
+		}
+		storage.writer.Lock()
+	}
+
+	storage.rootMutex.RLock()
+	root, closed := storage.root, storage.closed
+	storage.rootMutex.RUnlock()
+	if closed {
+		if writable {
+			storage.writer.Unlock()
+		}
+		return nil, TxClosedError{}
+	}
+
+	return &memoryTx{storage: storage, root: root, writable: writable}, nil
+}
+
+func (storage *MemoryStorage) View(procedure func(tx Tx) error) error {
+	tx, err := storage.Begin(false)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return procedure(tx)
+}
+
+func (storage *MemoryStorage) Update(procedure func(tx Tx) error) error {
+	tx, err := storage.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = procedure(tx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Batch is Update, there is no disk sync to amortize
+func (storage *MemoryStorage) Batch(procedure func(tx Tx) error) error {
+	return storage.Update(procedure)
+}
+
+func (storage *MemoryStorage) Close() error {
+	storage.rootMutex.Lock()
+	storage.closed = true
+	storage.rootMutex.Unlock()
+	return nil
+}
+
+func (bucket *memoryBucket) copyFor(tx *memoryTx) *memoryBucket {
+	bucket.keysMutex.Lock()
+	keys := bucket.keys // Never modified in place, only replaced
+	bucket.keysMutex.Unlock()
+
+	result := &memoryBucket{
+		owner:    tx,
+		values:   make(map[string][]byte, len(bucket.values)),
+		buckets:  make(map[string]*memoryBucket, len(bucket.buckets)),
+		sequence: bucket.sequence,
+		keys:     keys,
+	}
+	for key, value := range bucket.values {
+		result.values[key] = value // Values are never modified in place either
+	}
+	for name, nested := range bucket.buckets {
+		result.buckets[name] = nested
+	}
+	return result
+}
+
+func (bucket *memoryBucket) sortedKeys() []string {
+	bucket.keysMutex.Lock()
+	defer bucket.keysMutex.Unlock()
+	if bucket.keys == nil {
+		keys := make([]string, 0, len(bucket.values)+len(bucket.buckets))
+		for key := range bucket.values {
+			keys = append(keys, key)
+		}
+		for name := range bucket.buckets {
+			keys = append(keys, name)
+		}
+		sort.Strings(keys)
+		bucket.keys = keys
+	}
+	return bucket.keys
+}
+
+// lookup returns the value and whether the key exists, nil value with true is a nested bucket
+func (bucket *memoryBucket) lookup(key string) ([]byte, bool) {
+	if value, ok := bucket.values[key]; ok {
+		return value, true
+	}
+	_, ok := bucket.buckets[key]
+	return nil, ok
+}
+
+func (tx *memoryTx) writableRoot() (*memoryBucket, error) {
+	if tx.closed {
+		return nil, TxClosedError{}
+	}
+	if !tx.writable {
+		return nil, TxNotWritableError{}
+	}
+	if tx.root.owner != tx {
+		tx.root = tx.root.copyFor(tx)
+	}
+	return tx.root, nil
+}
+
+func (tx *memoryTx) handle(parent *memoryBucketHandle, name []byte) Bucket {
+	return &memoryBucketHandle{tx: tx, parent: parent, name: string(name)}
+}
+
+func (tx *memoryTx) Bucket(name []byte) Bucket {
+	if tx.closed || tx.root.buckets[string(name)] == nil {
+		return nil
+	}
+	return tx.handle(nil, name)
+}
+
+func createMemoryBucket(tx *memoryTx, parent *memoryBucket, name []byte, mustNotExist bool) error {
+	if len(name) == 0 {
+		return IncompatibleValueError{Key: ""}
+	}
+	if _, ok := parent.buckets[string(name)]; ok {
+		if mustNotExist {
+			return BucketExistsError{Name: string(name)}
+		}
+		return nil
+	}
+	if _, ok := parent.values[string(name)]; ok {
+		return IncompatibleValueError{Key: string(name)}
+	}
+	parent.buckets[string(name)] = newMemoryBucket(tx)
+	parent.keys = nil
+	return nil
+}
+
+func (tx *memoryTx) CreateBucket(name []byte) (Bucket, error) {
+	root, err := tx.writableRoot()
+	if err != nil {
+		return nil, err
+	}
+	err = createMemoryBucket(tx, root, name, true)
+	if err != nil {
+		return nil, err
+	}
+	return tx.handle(nil, name), nil
+}
+
+func (tx *memoryTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	root, err := tx.writableRoot()
+	if err != nil {
+		return nil, err
+	}
+	err = createMemoryBucket(tx, root, name, false)
+	if err != nil {
+		return nil, err
+	}
+	return tx.handle(nil, name), nil
+}
+
+func deleteMemoryBucket(parent *memoryBucket, name []byte) error {
+	if _, ok := parent.buckets[string(name)]; !ok {
+		return BucketNotFoundError{}
+	}
+	delete(parent.buckets, string(name))
+	parent.keys = nil
+	return nil
+}
+
+func (tx *memoryTx) DeleteBucket(name []byte) error {
+	root, err := tx.writableRoot()
+	if err != nil {
+		return err
+	}
+	return deleteMemoryBucket(root, name)
+}
+
+func (tx *memoryTx) ForEach(procedure func(name []byte, bucket Bucket) error) error {
+	if tx.closed {
+		return TxClosedError{}
+	}
+	for _, name := range tx.root.sortedKeys() {
+		err := procedure([]byte(name), tx.handle(nil, []byte(name)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *memoryTx) Writable() bool {
+	return tx.writable
+}
+
+func (tx *memoryTx) OnCommit(procedure func()) {
+	tx.onCommit = append(tx.onCommit, procedure)
+}
+
+func (tx *memoryTx) Commit() error {
+	if tx.closed {
+		return TxClosedError{}
+	}
+	if !tx.writable {
+		return TxNotWritableError{}
+	}
+
+	tx.storage.rootMutex.Lock()
+	tx.storage.root = tx.root
+	tx.storage.rootMutex.Unlock()
+	tx.closed = true
+	tx.storage.writer.Unlock()
+
+	onCommit := tx.onCommit
+	tx.onCommit = nil // Committed buckets keep pointing at the transaction
+	for _, procedure := range onCommit {
+		procedure()
+	}
+	return nil
+}
+
+func (tx *memoryTx) Rollback() error {
+	if tx.closed {
+		return TxClosedError{}
+	}
+	tx.closed = true
+	if tx.writable {
+		tx.storage.writer.Unlock()
+	}
+	return nil
+}
+
+// node is the current version of the bucket, nil if it or a bucket above it was deleted
+func (handle *memoryBucketHandle) node() *memoryBucket {
+	if handle.tx.closed {
+		return nil
+	}
+	parent := handle.tx.root
+	if handle.parent != nil {
+		parent = handle.parent.node()
+		if parent == nil {
+			return nil
+		}
+	}
+	return parent.buckets[handle.name]
+}
+
+// writableNode copies the bucket and its parents into the transaction if they aren't copied yet
+func (handle *memoryBucketHandle) writableNode() (*memoryBucket, error) {
+	var parent *memoryBucket
+	var err error
+	if handle.parent == nil {
+		parent, err = handle.tx.writableRoot()
+	} else {
+		parent, err = handle.parent.writableNode()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bucket := parent.buckets[handle.name]
+	if bucket == nil {
+		return nil, BucketNotFoundError{}
+	}
+	if bucket.owner != handle.tx {
+		bucket = bucket.copyFor(handle.tx)
+		parent.buckets[handle.name] = bucket
+	}
+	return bucket, nil
+}
+
+func (handle *memoryBucketHandle) Name() []byte {
+	return []byte(handle.name)
+}
+
+func (handle *memoryBucketHandle) Parent() Bucket {
+	if handle.parent == nil {
+		return nil
+	}
+	return handle.parent
+}
+
+func (handle *memoryBucketHandle) Tx() Tx {
+	return handle.tx
+}
+
+func (handle *memoryBucketHandle) Writable() bool {
+	return handle.tx.writable
+}
+
+func (handle *memoryBucketHandle) Get(key []byte) []byte {
+	bucket := handle.node()
+	if bucket == nil {
+		return nil
+	}
+	return bucket.values[string(key)]
+}
+
+func (handle *memoryBucketHandle) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return IncompatibleValueError{Key: ""}
+	}
+	bucket, err := handle.writableNode()
+	if err != nil {
+		return err
+	}
+	if _, ok := bucket.buckets[string(key)]; ok {
+		return IncompatibleValueError{Key: string(key)}
+	}
+
+	if _, ok := bucket.values[string(key)]; !ok {
+		bucket.keys = nil
+	}
+	bucket.values[string(key)] = append(make([]byte, 0, len(value)), value...) // Not nil even if empty, nil marks buckets
+	return nil
+}
+
+func (handle *memoryBucketHandle) Delete(key []byte) error {
+	bucket, err := handle.writableNode()
+	if err != nil {
+		return err
+	}
+	if _, ok := bucket.buckets[string(key)]; ok {
+		return IncompatibleValueError{Key: string(key)}
+	}
+	if _, ok := bucket.values[string(key)]; ok {
+		delete(bucket.values, string(key))
+		bucket.keys = nil
+	}
+	return nil
+}
+
+func (handle *memoryBucketHandle) Cursor() Cursor {
+	return &memoryCursor{bucket: handle}
+}
+
+func (handle *memoryBucketHandle) ForEach(procedure func(key, value []byte) error) error {
+	bucket := handle.node()
+	if bucket == nil {
+		return nil
+	}
+	for _, key := range bucket.sortedKeys() {
+		value, _ := bucket.lookup(key)
+		err := procedure([]byte(key), value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (handle *memoryBucketHandle) NextSequence() (uint64, error) {
+	bucket, err := handle.writableNode()
+	if err != nil {
+		return 0, err
+	}
+	bucket.sequence += 1
+	return bucket.sequence, nil
+}
+
+func (handle *memoryBucketHandle) Bucket(name []byte) Bucket {
+	bucket := handle.node()
+	if bucket == nil || bucket.buckets[string(name)] == nil {
+		return nil
+	}
+	return handle.tx.handle(handle, name)
+}
+
+func (handle *memoryBucketHandle) CreateBucket(name []byte) (Bucket, error) {
+	bucket, err := handle.writableNode()
+	if err != nil {
+		return nil, err
+	}
+	err = createMemoryBucket(handle.tx, bucket, name, true)
+	if err != nil {
+		return nil, err
+	}
+	return handle.tx.handle(handle, name), nil
+}
+
+func (handle *memoryBucketHandle) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	bucket, err := handle.writableNode()
+	if err != nil {
+		return nil, err
+	}
+	err = createMemoryBucket(handle.tx, bucket, name, false)
+	if err != nil {
+		return nil, err
+	}
+	return handle.tx.handle(handle, name), nil
+}
+
+func (handle *memoryBucketHandle) DeleteBucket(name []byte) error {
+	bucket, err := handle.writableNode()
+	if err != nil {
+		return err
+	}
+	return deleteMemoryBucket(bucket, name)
+}
+
+/*
+memoryCursor remembers the key it is at rather than a position, so it keeps working while the bucket is modified
+under it: Next after a Delete goes to the key after the deleted one.
+*/
+func (cursor *memoryCursor) at(keys []string, i int) ([]byte, []byte) {
+	bucket := cursor.bucket.node()
+	if bucket == nil || i < 0 || i >= len(keys) {
+		cursor.valid = false
+		return nil, nil
+	}
+	cursor.key = keys[i]
+	cursor.valid = true
+	value, _ := bucket.lookup(cursor.key)
+	return []byte(cursor.key), value
+}
+
+func (cursor *memoryCursor) keys() []string {
+	bucket := cursor.bucket.node()
+	if bucket == nil {
+		return nil
+	}
+	return bucket.sortedKeys()
+}
+
+func (cursor *memoryCursor) First() ([]byte, []byte) {
+	return cursor.at(cursor.keys(), 0)
+}
+
+func (cursor *memoryCursor) Last() ([]byte, []byte) {
+	keys := cursor.keys()
+	return cursor.at(keys, len(keys)-1)
+}
+
+func (cursor *memoryCursor) Next() ([]byte, []byte) {
+	if !cursor.valid {
+		return nil, nil
+	}
+	keys := cursor.keys()
+	return cursor.at(keys, sort.Search(len(keys), func(i int) bool { return keys[i] > cursor.key }))
+}
+
+func (cursor *memoryCursor) Prev() ([]byte, []byte) {
+	if !cursor.valid {
+		return nil, nil
+	}
+	keys := cursor.keys()
+	return cursor.at(keys, sort.SearchStrings(keys, cursor.key)-1)
+}
+
+func (cursor *memoryCursor) Seek(seek []byte) ([]byte, []byte) {
+	keys := cursor.keys()
+	return cursor.at(keys, sort.SearchStrings(keys, string(seek)))
+}
+
+func (cursor *memoryCursor) Delete() error {
+	if !cursor.valid {
+		return nil
+	}
+	return cursor.bucket.Delete([]byte(cursor.key))
+}
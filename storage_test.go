@@ -0,0 +1,323 @@
+package easyframework
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// forEachStorage runs the test against every backend, they must behave the same
+func forEachStorage(t *testing.T, test func(t *testing.T, storage Storage)) {
+	t.Run("bolt", func(t *testing.T) {
+		// Bolt can't grow the map while a read transaction is open, a commit then waits for it forever
+		storage, err := OpenBoltStorage(filepath.Join(t.TempDir(), "test.db"), 0600, &bolt.Options{InitialMmapSize: 1 << 20})
+		if err != nil {
+			t.Fatalf("OpenBoltStorage: %v", err)
+		}
+		defer storage.Close()
+		test(t, storage)
+	})
+	t.Run("memory", func(t *testing.T) {
+		storage := NewMemoryStorage()
+		defer storage.Close()
+		test(t, storage)
+	})
+}
+
+func putTestValues(t *testing.T, storage Storage, bucketName string, values map[string]string) {
+	t.Helper()
+	err := storage.Update(func(tx Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(bucketName))
+		if err != nil {
+			return err
+		}
+		for key, value := range values {
+			err := bucket.Put([]byte(key), []byte(value))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+}
+
+func getTestValue(t *testing.T, tx Tx, bucketName string, key string) string {
+	t.Helper()
+	bucket := tx.Bucket([]byte(bucketName))
+	if bucket == nil {
+		t.Fatalf("Bucket %v doesn't exist", bucketName)
+	}
+	return string(bucket.Get([]byte(key)))
+}
+
+func TestStorageSnapshotIsolation(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage) {
+		putTestValues(t, storage, "items", map[string]string{"a": "1"})
+
+		reader, err := storage.Begin(false)
+		if err != nil {
+			t.Fatalf("Begin: %v", err)
+		}
+		defer reader.Rollback()
+
+		writer, err := storage.Begin(true)
+		if err != nil {
+			t.Fatalf("Begin: %v", err)
+		}
+		err = writer.Bucket([]byte("items")).Put([]byte("a"), []byte("2"))
+		if err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		_, err = writer.CreateBucket([]byte("created"))
+		if err != nil {
+			t.Fatalf("CreateBucket: %v", err)
+		}
+		if value := getTestValue(t, writer, "items", "a"); value != "2" {
+			t.Fatalf("Writer doesn't see its own write, got %q", value)
+		}
+		if value := getTestValue(t, reader, "items", "a"); value != "1" {
+			t.Fatalf("Reader sees an uncommitted write, got %q", value)
+		}
+
+		err = writer.Commit()
+		if err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+		if value := getTestValue(t, reader, "items", "a"); value != "1" {
+			t.Fatalf("Reader sees a write committed after it started, got %q", value)
+		}
+		if reader.Bucket([]byte("created")) != nil {
+			t.Fatalf("Reader sees a bucket created after it started")
+		}
+
+		err = storage.View(func(tx Tx) error {
+			if value := getTestValue(t, tx, "items", "a"); value != "2" {
+				t.Fatalf("New reader doesn't see the committed write, got %q", value)
+			}
+			if tx.Bucket([]byte("created")) == nil {
+				t.Fatalf("New reader doesn't see the created bucket")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("View: %v", err)
+		}
+	})
+}
+
+func TestStorageCursorOrderAndDelete(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage) {
+		putTestValues(t, storage, "items", map[string]string{"d": "4", "b": "2", "a": "1", "c": "3", "e": "5", "\xff": "6"})
+
+		err := storage.Update(func(tx Tx) error {
+			var seen string
+			cursor := tx.Bucket([]byte("items")).Cursor()
+			for key, _ := cursor.First(); key != nil; key, _ = cursor.Next() {
+				seen += string(key)
+				if string(key) == "b" || string(key) == "c" || string(key) == "\xff" {
+					err := cursor.Delete()
+					if err != nil {
+						return err
+					}
+				}
+			}
+			if seen != "abcde\xff" {
+				t.Fatalf("Next after Delete skipped or repeated keys, saw %q", seen)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+
+		err = storage.View(func(tx Tx) error {
+			var keys string
+			cursor := tx.Bucket([]byte("items")).Cursor()
+			for key, _ := cursor.Last(); key != nil; key, _ = cursor.Prev() {
+				keys += string(key)
+			}
+			if keys != "eda" {
+				t.Fatalf("Expected eda backwards, got %q", keys)
+			}
+
+			key, value := cursor.Seek([]byte("b"))
+			if string(key) != "d" || string(value) != "4" {
+				t.Fatalf("Seek b expected d, got %q %q", key, value)
+			}
+			key, _ = cursor.Seek([]byte("f"))
+			if key != nil {
+				t.Fatalf("Seek past the end expected nil, got %q", key)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("View: %v", err)
+		}
+	})
+}
+
+func TestStorageNestedBuckets(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage) {
+		err := storage.Update(func(tx Tx) error {
+			parent, err := tx.CreateBucket([]byte("parent"))
+			if err != nil {
+				return err
+			}
+			_, err = tx.CreateBucket([]byte("parent"))
+			if err == nil {
+				t.Fatalf("CreateBucket of an existing bucket succeeded")
+			}
+
+			err = parent.Put([]byte("value"), []byte("1"))
+			if err != nil {
+				return err
+			}
+			nested, err := parent.CreateBucket([]byte("nested"))
+			if err != nil {
+				return err
+			}
+			err = nested.Put([]byte("inner"), []byte("2"))
+			if err != nil {
+				return err
+			}
+			again, err := parent.CreateBucketIfNotExists([]byte("nested"))
+			if err != nil {
+				return err
+			}
+			if string(again.Get([]byte("inner"))) != "2" {
+				t.Fatalf("CreateBucketIfNotExists didn't return the existing bucket")
+			}
+			if parent.Bucket([]byte("value")) != nil {
+				t.Fatalf("Bucket of a value key is not nil")
+			}
+			if parent.Bucket([]byte("missing")) != nil {
+				t.Fatalf("Bucket of a missing key is not nil")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+
+		err = storage.View(func(tx Tx) error {
+			parent := tx.Bucket([]byte("parent"))
+			nested := parent.Bucket([]byte("nested"))
+			if nested == nil || string(nested.Get([]byte("inner"))) != "2" {
+				t.Fatalf("Nested bucket is missing after commit")
+			}
+			if string(nested.Name()) != "nested" {
+				t.Fatalf("Nested bucket name is %q", nested.Name())
+			}
+			if parent.Parent() != nil || nested.Parent() == nil || string(nested.Parent().Name()) != "parent" {
+				t.Fatalf("Wrong parents, top level %v, nested %v", parent.Parent(), nested.Parent())
+			}
+			if name, found := BucketName(parent); !found || name != "parent" {
+				t.Fatalf("BucketName of a top level bucket is %q %v", name, found)
+			}
+			if _, found := BucketName(nested); found {
+				t.Fatalf("BucketName found a nested bucket")
+			}
+
+			values := make(map[string]bool)
+			cursor := parent.Cursor()
+			for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+				values[string(key)] = value == nil
+			}
+			if len(values) != 2 || !values["nested"] || values["value"] {
+				t.Fatalf("Expected nested with a nil value and value with a value, got %v", values)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("View: %v", err)
+		}
+
+		err = storage.Update(func(tx Tx) error {
+			return tx.Bucket([]byte("parent")).DeleteBucket([]byte("nested"))
+		})
+		if err != nil {
+			t.Fatalf("DeleteBucket: %v", err)
+		}
+		err = storage.View(func(tx Tx) error {
+			if tx.Bucket([]byte("parent")).Bucket([]byte("nested")) != nil {
+				t.Fatalf("Nested bucket is still there after DeleteBucket")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("View: %v", err)
+		}
+	})
+}
+
+func TestStorageOnCommitAndRollback(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage) {
+		committed := 0
+		err := storage.Update(func(tx Tx) error {
+			tx.OnCommit(func() { committed += 1 })
+			bucket, err := tx.CreateBucket([]byte("items"))
+			if err != nil {
+				return err
+			}
+			return bucket.Put([]byte("a"), []byte("1"))
+		})
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		if committed != 1 {
+			t.Fatalf("OnCommit ran %v times after a commit", committed)
+		}
+
+		failure := errors.New("failure")
+		err = storage.Update(func(tx Tx) error {
+			tx.OnCommit(func() { committed += 1 })
+			err := tx.Bucket([]byte("items")).Put([]byte("a"), []byte("2"))
+			if err != nil {
+				return err
+			}
+			return failure
+		})
+		if err != failure {
+			t.Fatalf("Update returned %v, expected the procedure error", err)
+		}
+
+		tx, err := storage.Begin(true)
+		if err != nil {
+			t.Fatalf("Begin: %v", err)
+		}
+		tx.OnCommit(func() { committed += 1 })
+		err = tx.Bucket([]byte("items")).Put([]byte("b"), []byte("1"))
+		if err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		err = tx.Rollback()
+		if err != nil {
+			t.Fatalf("Rollback: %v", err)
+		}
+		if committed != 1 {
+			t.Fatalf("OnCommit ran after a rollback")
+		}
+
+		err = storage.View(func(tx Tx) error {
+			bucket := tx.Bucket([]byte("items"))
+			if string(bucket.Get([]byte("a"))) != "1" || bucket.Get([]byte("b")) != nil {
+				t.Fatalf("Rolled back writes are visible")
+			}
+			if bucket.Writable() || tx.Writable() {
+				t.Fatalf("Read transaction is writable")
+			}
+			if bucket.Put([]byte("c"), []byte("1")) == nil {
+				t.Fatalf("Put in a read transaction succeeded")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("View: %v", err)
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package easyframework
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// TransactionMode of a procedure, the dispatcher opens the transaction and puts it into RequestContext.Tx
+type TransactionMode int8
+
+const (
+	TRANSACTION_NONE  TransactionMode = iota
+	TRANSACTION_READ                  // Rolled back after the call
+	TRANSACTION_WRITE                 // Committed if the returned Problem is empty, rolled back on a Problem or a panic. Not with CustomResponse.
+)
+
+func (mode TransactionMode) String() string {
+	switch mode {
+	case TRANSACTION_READ:
+		return "read"
+	case TRANSACTION_WRITE:
+		return "write"
+	}
+	return "none"
+}
+
+type NestedWriteTransactionError struct{}
+
+func (v NestedWriteTransactionError) Error() string {
+	return "Write transaction opened by the handler of a write procedure, it would wait on the procedure forever. Use RequestContext.Tx."
+}
+
+// Goroutines that run the handler of a write procedure, storage can allow only one writer at a time
+var procedureWriters sync.Map
+var procedureWritersCount atomic.Int32
+
+/*
+checkNestedWrite is called by storage before a write transaction begins. Handlers of write procedures must not call
+functions that open their own write transaction (InsertByID, UpdateByID, ...), they get NestedWriteTransactionError
+instead of a deadlock. Goroutines the handler starts are not known, they still wait.
+*/
+func checkNestedWrite() error {
+	if procedureWritersCount.Load() == 0 { // Goroutine ID is slow to get, don't for every write
+		return nil
+	}
+	if _, writing := procedureWriters.Load(curGoroutineID()); writing {
+		return NestedWriteTransactionError{}
+	}
+	return nil
+}
+
+// beginProcedureTransaction is called by the dispatcher right before the handler, the caller defers endProcedureTransaction
+func beginProcedureTransaction(ctx *Context, procedure *Procedure) (Tx, error) {
+	if procedure.Transaction == TRANSACTION_NONE {
+		return nil, nil
+	}
+	tx, err := ctx.Database.Begin(procedure.Transaction == TRANSACTION_WRITE)
+	if err != nil {
+		return nil, err
+	}
+
+	if tx.Writable() {
+		procedureWriters.Store(curGoroutineID(), struct{}{})
+		procedureWritersCount.Add(1)
+	}
+	return tx, nil
+}
+
+// commitProcedureTransaction is called once the handler returned without a Problem
+func commitProcedureTransaction(tx Tx) error {
+	if tx == nil || !tx.Writable() {
+		return nil
+	}
+	return tx.Commit()
+}
+
+// endProcedureTransaction rolls back what wasn't committed, also on panic
+func endProcedureTransaction(tx Tx) {
+	if tx.Writable() {
+		procedureWriters.Delete(curGoroutineID())
+		procedureWritersCount.Add(-1)
+	}
+	tx.Rollback()
+}
@@ -0,0 +1,68 @@
+package easyframework
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type transactionTestRecord struct {
+	Name string `id:"1"`
+}
+
+func TestNestedWriteInWriteProcedure(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage) {
+		ctx := &Context{Database: storage}
+		err := NewBucket(ctx, "transaction_test")
+		if err != nil {
+			t.Fatalf("NewBucket: %v", err)
+		}
+
+		done := make(chan error)
+		go func() {
+			tx, err := beginProcedureTransaction(ctx, &Procedure{Transaction: TRANSACTION_WRITE})
+			if err != nil {
+				done <- err
+				return
+			}
+			defer endProcedureTransaction(tx)
+			done <- InsertByID(ctx, "transaction_test", NewID128(), &transactionTestRecord{Name: "nested"})
+		}()
+
+		select {
+		case err := <-done:
+			var nested NestedWriteTransactionError
+			if !errors.As(err, &nested) {
+				t.Fatalf("Expected NestedWriteTransactionError, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Nested write transaction deadlocked")
+		}
+
+		// The procedure is over, writes go through again
+		err = InsertByID(ctx, "transaction_test", NewID128(), &transactionTestRecord{Name: "after"})
+		if err != nil {
+			t.Fatalf("InsertByID after the procedure: %v", err)
+		}
+	})
+}
+
+func TestWriteTransactionWithCustomResponse(t *testing.T) {
+	ctx := new(Context)
+	err := Initialize(ctx, InitializeParams{Storage: NewMemoryStorage()})
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("NewRPC accepted a write transaction with a custom response")
+		}
+	}()
+	NewRPC(ctx, NewRPCParams{
+		Name:           "Test.Custom",
+		Handler:        func(requestContext *RequestContext) (problem Problem) { return },
+		CustomResponse: true,
+		Transaction:    TRANSACTION_WRITE,
+	})
+}
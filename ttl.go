@@ -0,0 +1,253 @@
+package easyframework
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"sync"
+	"time"
+)
+
+/*
+Expiring records are tracked in two places:
+	BUCKET_EXPIRY, key is (expires at, record ID, bucket name), ordered by time so the sweeper only looks at the start of it
+	"\x00ef_expiry" nested in the record bucket, record ID -> (expires at, bucket name), so reads and writes find the expiry of a record
+Times are unix milliseconds, big endian.
+*/
+
+const BUCKET_EXPIRY BucketID = "ef_expiry"
+
+const EXPIRY_NESTED_BUCKET = "\x00ef_expiry"
+
+const EXPIRY_SWEEP_BATCH = 1000
+
+var expiringBucketsMutex sync.RWMutex
+var expiringBuckets map[BucketID]func(bucket Bucket, ID ID128) error
+
+// registerExpiringBucket lets the sweeper delete records through Delete[T], so their indexes are cleaned up too
+func registerExpiringBucket[T any](bucketID BucketID) {
+	expiringBucketsMutex.Lock()
+	defer expiringBucketsMutex.Unlock()
+
+	if expiringBuckets == nil {
+		expiringBuckets = make(map[BucketID]func(bucket Bucket, ID ID128) error)
+	}
+	if _, ok := expiringBuckets[bucketID]; !ok {
+		expiringBuckets[bucketID] = Delete[T]
+	}
+}
+
+func expiryKey(expiresAt int64, ID ID128, bucketID BucketID) []byte {
+	key := make([]byte, 8, 8+16+len(bucketID))
+	binary.BigEndian.PutUint64(key, uint64(expiresAt))
+	key = append(key, ID[:]...)
+	return append(key, bucketID...)
+}
+
+// InsertWithExpiry is Insert for a record that disappears at expiresAt
+func InsertWithExpiry[T any](tx Tx, bucketID BucketID, ID ID128, value *T, expiresAt time.Time) error {
+	registerExpiringBucket[T](bucketID)
+
+	bucket, err := GetBucket(tx, bucketID)
+	if err != nil {
+		return err
+	}
+
+	err = Insert(bucket, ID, value) // Clears the previous expiry
+	if err != nil {
+		return err
+	}
+
+	expiry, err := tx.CreateBucketIfNotExists([]byte(BUCKET_EXPIRY))
+	if err != nil {
+		return err
+	}
+	nested, err := bucket.CreateBucketIfNotExists([]byte(EXPIRY_NESTED_BUCKET))
+	if err != nil {
+		return err
+	}
+
+	key := expiryKey(expiresAt.UnixMilli(), ID, bucketID)
+	err = expiry.Put(key, []byte{})
+	if err != nil {
+		return err
+	}
+
+	nestedValue := append(key[:8:8], bucketID...)
+	return nested.Put(ID[:], nestedValue)
+}
+
+func InsertByIDWithTTL[T any](ctx *Context, bucketID BucketID, ID ID128, value *T, ttl time.Duration) error {
+	return ctx.Database.Update(func(tx Tx) error {
+		return InsertWithExpiry(tx, bucketID, ID, value, time.Now().Add(ttl))
+	})
+}
+
+// clearExpiry removes the expiry of the record from both places, done whenever the record is replaced or deleted
+func clearExpiry(bucket Bucket, ID ID128) error {
+	nested := bucket.Bucket([]byte(EXPIRY_NESTED_BUCKET))
+	if nested == nil {
+		return nil
+	}
+	nestedValue := nested.Get(ID[:])
+	if len(nestedValue) < 8 {
+		return nil
+	}
+
+	expiry := bucket.Tx().Bucket([]byte(BUCKET_EXPIRY))
+	if expiry != nil {
+		key := expiryKey(int64(binary.BigEndian.Uint64(nestedValue)), ID, BucketID(nestedValue[8:]))
+		err := expiry.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nested.Delete(ID[:])
+}
+
+// ExpiryChecker tells whether records of a bucket are expired, the sweeper might not have removed them yet
+type ExpiryChecker struct {
+	nested Bucket
+	now    int64
+}
+
+func NewExpiryChecker(bucket Bucket) ExpiryChecker {
+	return ExpiryChecker{
+		nested: bucket.Bucket([]byte(EXPIRY_NESTED_BUCKET)),
+		now:    time.Now().UnixMilli(),
+	}
+}
+
+func (checker ExpiryChecker) IsExpired(key []byte) bool {
+	if checker.nested == nil {
+		return false
+	}
+
+	nestedValue := checker.nested.Get(key)
+	if len(nestedValue) < 8 {
+		return false
+	}
+	return int64(binary.BigEndian.Uint64(nestedValue)) <= checker.now
+}
+
+func IsExpired(bucket Bucket, ID ID128) bool {
+	return NewExpiryChecker(bucket).IsExpired(ID[:])
+}
+
+// GetExpiry returns when the record expires, false if it doesn't
+func GetExpiry(bucket Bucket, ID ID128) (time.Time, bool) {
+	nested := bucket.Bucket([]byte(EXPIRY_NESTED_BUCKET))
+	if nested == nil {
+		return time.Time{}, false
+	}
+	nestedValue := nested.Get(ID[:])
+	if len(nestedValue) < 8 {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(int64(binary.BigEndian.Uint64(nestedValue))), true
+}
+
+type expiredRecord struct {
+	Key      []byte
+	ID       ID128
+	BucketID BucketID
+}
+
+// SweepExpired deletes records that expired, returns how many. Works in batches, one transaction each.
+func SweepExpired(ctx *Context) (removed int, err error) {
+	for {
+		batchRemoved := 0
+		err = ctx.Database.Update(func(tx Tx) error {
+			expiry := tx.Bucket([]byte(BUCKET_EXPIRY))
+			if expiry == nil {
+				return nil
+			}
+
+			var now [8]byte
+			binary.BigEndian.PutUint64(now[:], uint64(time.Now().UnixMilli()))
+
+			var expired []expiredRecord
+			cursor := expiry.Cursor()
+			for key, _ := cursor.First(); key != nil && len(expired) < EXPIRY_SWEEP_BATCH; key, _ = cursor.Next() {
+				if bytes.Compare(key[:8], now[:]) > 0 {
+					break
+				}
+				expired = append(expired, expiredRecord{
+					Key:      append([]byte(nil), key...),
+					ID:       ID128(key[8:24]),
+					BucketID: BucketID(key[24:]),
+				})
+			}
+
+			expiringBucketsMutex.RLock()
+			defer expiringBucketsMutex.RUnlock()
+
+			for _, record := range expired {
+				bucket := tx.Bucket([]byte(record.BucketID))
+				if bucket == nil { // Bucket is gone, only the entry is left
+					err := expiry.Delete(record.Key)
+					if err != nil {
+						return err
+					}
+					continue
+				}
+
+				var err error
+				if deleter, ok := expiringBuckets[record.BucketID]; ok {
+					err = deleter(bucket, record.ID)
+				} else if bucketType, ok := lookupBucketType(record.BucketID); ok {
+					err = bucketType.delete(bucket, record.ID)
+				} else { // Nothing inserted into this bucket since the start and the type isn't registered
+					err = deleteUntyped(bucket, record.ID)
+				}
+				if err != nil {
+					return err
+				}
+
+				err = expiry.Delete(record.Key) // In case the nested entry was already missing
+				if err != nil {
+					return err
+				}
+			}
+
+			batchRemoved = len(expired)
+			return nil
+		})
+
+		removed += batchRemoved
+		if err != nil || batchRemoved < EXPIRY_SWEEP_BATCH {
+			return removed, err
+		}
+	}
+}
+
+// deleteUntyped does what Delete does for a record of an unknown type, except telling subscribers
+func deleteUntyped(bucket Bucket, ID ID128) error {
+	err := removeIndexEntriesOf(bucket, ID)
+	if err != nil {
+		return err
+	}
+	err = clearExpiry(bucket, ID)
+	if err != nil {
+		return err
+	}
+	err = removeVersion(bucket, ID)
+	if err != nil {
+		return err
+	}
+	return bucket.Delete(ID[:])
+}
+
+func ExpirySweeperRoutine(ctx *Context) {
+	for {
+		time.Sleep(ctx.ExpirySweepPeriod)
+
+		removed, err := SweepExpired(ctx)
+		if err != nil {
+			log.Printf("Expiry sweep failed: %v", err)
+		} else if removed > 0 {
+			log.Printf("Expiry sweep: %v records removed", removed)
+		}
+	}
+}
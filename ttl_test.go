@@ -0,0 +1,191 @@
+package easyframework
+
+import (
+	"testing"
+	"time"
+)
+
+type ttlTestRecord struct {
+	Name string `id:"1" index:"ttl_test_name,unique"`
+}
+
+func newTTLTestContext(t *testing.T) *Context {
+	t.Helper()
+	ctx := new(Context)
+	err := Initialize(ctx, InitializeParams{Storage: NewMemoryStorage()})
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	err = NewBucket(ctx, "ttl_test")
+	if err != nil {
+		t.Fatalf("NewBucket: %v", err)
+	}
+	return ctx
+}
+
+func insertExpiring(t *testing.T, ctx *Context, ID ID128, name string, expiresAt time.Time) {
+	t.Helper()
+	err := ctx.Database.Update(func(tx Tx) error {
+		return InsertWithExpiry(tx, "ttl_test", ID, &ttlTestRecord{Name: name}, expiresAt)
+	})
+	if err != nil {
+		t.Fatalf("InsertWithExpiry: %v", err)
+	}
+}
+
+func countBucket(t *testing.T, ctx *Context, bucketID BucketID) int {
+	t.Helper()
+	count := 0
+	ctx.Database.View(func(tx Tx) error {
+		bucket := tx.Bucket([]byte(bucketID))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+			if value != nil {
+				count += 1
+			}
+		}
+		return nil
+	})
+	return count
+}
+
+func TestExpiredRecordsAreHiddenAndSwept(t *testing.T) {
+	ctx := newTTLTestContext(t)
+	expired, live := NewID128(), NewID128()
+	insertExpiring(t, ctx, expired, "expired", time.Now().Add(-time.Second))
+	insertExpiring(t, ctx, live, "live", time.Now().Add(time.Hour))
+
+	var record ttlTestRecord
+	if GetByID(ctx, "ttl_test", expired, &record) {
+		t.Fatalf("Expired record was returned before the sweep")
+	}
+	if !GetByID(ctx, "ttl_test", live, &record) {
+		t.Fatalf("Live record was not returned")
+	}
+	ctx.Database.View(func(tx Tx) error {
+		var seen []string
+		Iterate(tx.Bucket([]byte("ttl_test")), func(ID ID128, record *ttlTestRecord) bool {
+			seen = append(seen, record.Name)
+			return true
+		})
+		if len(seen) != 1 || seen[0] != "live" {
+			t.Fatalf("Iterate returned %v", seen)
+		}
+		return nil
+	})
+
+	removed, err := SweepExpired(ctx)
+	if err != nil || removed != 1 {
+		t.Fatalf("SweepExpired removed %v: %v", removed, err)
+	}
+	if countBucket(t, ctx, BUCKET_EXPIRY) != 1 {
+		t.Fatalf("Expiry entry of the swept record is left")
+	}
+	ctx.Database.View(func(tx Tx) error {
+		bucket := tx.Bucket([]byte("ttl_test"))
+		if bucket.Get(expired[:]) != nil {
+			t.Fatalf("Expired record is still stored")
+		}
+		if _, ok := GetExpiry(bucket, expired); ok {
+			t.Fatalf("Nested expiry of the swept record is left")
+		}
+		if countBucket(t, ctx, "ttl_test") != 1 {
+			t.Fatalf("Sweep removed more than the expired record")
+		}
+		return nil
+	})
+
+	err = InsertByID(ctx, "ttl_test", NewID128(), &ttlTestRecord{Name: "expired"})
+	if err != nil {
+		t.Fatalf("Value of the swept record is still in the unique index: %v", err)
+	}
+}
+
+func TestInsertReplacesExpiry(t *testing.T) {
+	ctx := newTTLTestContext(t)
+	ID := NewID128()
+	insertExpiring(t, ctx, ID, "first", time.Now().Add(time.Hour))
+	later := time.Now().Add(2 * time.Hour)
+	insertExpiring(t, ctx, ID, "second", later)
+
+	if countBucket(t, ctx, BUCKET_EXPIRY) != 1 {
+		t.Fatalf("Previous expiry entry was not replaced")
+	}
+	ctx.Database.View(func(tx Tx) error {
+		expiresAt, ok := GetExpiry(tx.Bucket([]byte("ttl_test")), ID)
+		if !ok || expiresAt.UnixMilli() != later.UnixMilli() {
+			t.Fatalf("Expiry is %v %v, expected %v", expiresAt, ok, later)
+		}
+		return nil
+	})
+
+	err := InsertByID(ctx, "ttl_test", ID, &ttlTestRecord{Name: "forever"})
+	if err != nil {
+		t.Fatalf("InsertByID: %v", err)
+	}
+	if countBucket(t, ctx, BUCKET_EXPIRY) != 0 {
+		t.Fatalf("Insert without expiry kept the expiry")
+	}
+}
+
+func TestSweepInBatches(t *testing.T) {
+	ctx := newTTLTestContext(t)
+	err := ctx.Database.Update(func(tx Tx) error {
+		for i := 0; i < EXPIRY_SWEEP_BATCH+10; i += 1 {
+			err := InsertWithExpiry(tx, "ttl_test", NewID128(), &ttlTestRecord{}, time.Now().Add(-time.Second))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("InsertWithExpiry: %v", err)
+	}
+
+	removed, err := SweepExpired(ctx)
+	if err != nil || removed != EXPIRY_SWEEP_BATCH+10 {
+		t.Fatalf("SweepExpired removed %v: %v", removed, err)
+	}
+	if countBucket(t, ctx, "ttl_test") != 0 || countBucket(t, ctx, BUCKET_EXPIRY) != 0 {
+		t.Fatalf("Records or expiry entries are left")
+	}
+}
+
+func TestDeleteUntypedClearsEverything(t *testing.T) {
+	ctx := newTTLTestContext(t)
+	err := EnableVersions(ctx, "ttl_test")
+	if err != nil {
+		t.Fatalf("EnableVersions: %v", err)
+	}
+	ID := NewID128()
+	insertExpiring(t, ctx, ID, "untyped", time.Now().Add(time.Hour))
+
+	err = ctx.Database.Update(func(tx Tx) error {
+		return deleteUntyped(tx.Bucket([]byte("ttl_test")), ID)
+	})
+	if err != nil {
+		t.Fatalf("deleteUntyped: %v", err)
+	}
+
+	ctx.Database.View(func(tx Tx) error {
+		bucket := tx.Bucket([]byte("ttl_test"))
+		if GetVersion(bucket, ID) != 0 {
+			t.Fatalf("Version is left")
+		}
+		if _, ok := GetExpiry(bucket, ID); ok {
+			t.Fatalf("Expiry is left")
+		}
+		_, _, found, err := FindByIndex[ttlTestRecord](bucket, "ttl_test_name", "untyped")
+		if err != nil || found {
+			t.Fatalf("Index entry is left: %v", err)
+		}
+		return nil
+	})
+	if countBucket(t, ctx, BUCKET_EXPIRY) != 0 {
+		t.Fatalf("Expiry entry is left")
+	}
+}
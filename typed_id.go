@@ -0,0 +1,80 @@
+package easyframework
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+/*
+ID[T] is an ID128 that can only identify a T, ID[User] and ID[Session] don't convert into each other implicitly.
+It has the same layout as ID128, so it is packed, indexed and stored the same way, and all text formats are shared.
+*/
+type ID[T any] ID128
+
+// User stands for the users of the application, whose records the framework doesn't know. Sessions, API keys and
+// RequestContext.UserID hold an ID[User], keep the IDs of user records as ID[User] too to pass them without conversion.
+type User struct{}
+
+func NewID[T any]() ID[T] {
+	return ID[T](NewID128())
+}
+
+func NewSortableID[T any]() ID[T] {
+	return ID[T](NewSortableID128())
+}
+
+func ParseID[T any](str string) (ID[T], error) {
+	id, err := ParseID128(str)
+	return ID[T](id), err
+}
+
+// Raw drops the type, for APIs that take any ID128
+func (id ID[T]) Raw() ID128 {
+	return ID128(id)
+}
+
+func (id ID[T]) IsZero() bool {
+	return id == ID[T]{}
+}
+
+func (id ID[T]) String() string {
+	return ID128(id).String()
+}
+
+func (id ID[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(id.String())
+}
+
+func (id *ID[T]) UnmarshalJSON(src []byte) error {
+	if bytes.Equal(src, []byte("null")) {
+		return nil
+	}
+	return (*ID128)(id).UnmarshalJSON(src)
+}
+
+func (id ID[T]) MarshalText() ([]byte, error) {
+	return ID128(id).MarshalText()
+}
+
+func (id *ID[T]) UnmarshalText(text []byte) error {
+	return (*ID128)(id).UnmarshalText(text)
+}
+
+func (id ID[T]) Value() (driver.Value, error) {
+	return ID128(id).Value()
+}
+
+func (id *ID[T]) Scan(src interface{}) error {
+	return (*ID128)(id).Scan(src)
+}
+
+// PathID parses a path variable of a REST procedure, e.g. "{id}" of "/users/{id}"
+func PathID[T any](requestContext *RequestContext, name string) (ID[T], error) {
+	value, ok := requestContext.Vars[name]
+	if !ok {
+		return ID[T]{}, fmt.Errorf("Path variable %v is missing", name)
+	}
+	return ParseID[T](value)
+}
@@ -0,0 +1,203 @@
+package easyframework
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+/*
+Record versions live in "\x00ef_versions" nested in the record bucket, record ID -> version.
+Once versions are enabled for a bucket every Insert gives the record a new version, so writers that don't
+know about versions still make CompareAndSwap of others fail. Versions come from the sequence of the nested
+bucket, a deleted and recreated record never gets a version it had before.
+*/
+
+const VERSIONS_NESTED_BUCKET = "\x00ef_versions"
+
+// UPDATE_RETRIES is how many times UpdateByID runs the procedure before giving up on conflicts
+const UPDATE_RETRIES = 10
+
+type VersionConflictError struct {
+	ID       ID128
+	Expected int64
+	Actual   int64 // Zero if the record doesn't exist
+}
+
+func (v VersionConflictError) Error() string {
+	return fmt.Sprintf("Record %v was changed, expected version %v, found %v", v.ID, v.Expected, v.Actual)
+}
+
+type VersionsNotEnabledError struct{}
+
+func (v VersionsNotEnabledError) Error() string {
+	return "Versions are not enabled for the bucket, see EnableVersions"
+}
+
+// EnableVersions starts tracking versions in the bucket, records that exist already get their first version
+func EnableVersions(ctx *Context, bucketID BucketID) error {
+	return ctx.Database.Update(func(tx Tx) error {
+		bucket, err := GetBucket(tx, bucketID)
+		if err != nil {
+			return err
+		}
+		if bucket.Bucket([]byte(VERSIONS_NESTED_BUCKET)) != nil {
+			return nil
+		}
+
+		_, err = bucket.CreateBucket([]byte(VERSIONS_NESTED_BUCKET))
+		if err != nil {
+			return err
+		}
+
+		var keys []ID128
+		cursor := bucket.Cursor()
+		for key, data := cursor.First(); key != nil; key, data = cursor.Next() {
+			if data != nil {
+				keys = append(keys, ID128(key))
+			}
+		}
+		for _, ID := range keys {
+			err := bumpVersion(bucket, ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetVersion returns the current version of the record, zero if it doesn't exist
+func GetVersion(bucket Bucket, ID ID128) int64 {
+	versions := bucket.Bucket([]byte(VERSIONS_NESTED_BUCKET))
+	if versions == nil {
+		return 0
+	}
+
+	data := versions.Get(ID[:])
+	if len(data) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(data))
+}
+
+// bumpVersion is done by Insert
+func bumpVersion(bucket Bucket, ID ID128) error {
+	versions := bucket.Bucket([]byte(VERSIONS_NESTED_BUCKET))
+	if versions == nil {
+		return nil
+	}
+
+	version, err := versions.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], version)
+	return versions.Put(ID[:], data[:])
+}
+
+// removeVersion is done by Delete
+func removeVersion(bucket Bucket, ID ID128) error {
+	versions := bucket.Bucket([]byte(VERSIONS_NESTED_BUCKET))
+	if versions == nil {
+		return nil
+	}
+	return versions.Delete(ID[:])
+}
+
+// GetWithVersion returns the record with its version, pass the version to CompareAndSwap
+func GetWithVersion[T any](bucket Bucket, ID ID128) (value T, version int64, found bool, err error) {
+	data := bucket.Get(ID[:])
+	if data == nil || IsExpired(bucket, ID) {
+		return value, 0, false, nil
+	}
+
+	err = Unpack(data, &value)
+	if err != nil {
+		return value, 0, false, err
+	}
+
+	return value, GetVersion(bucket, ID), true, nil
+}
+
+/*
+CompareAndSwap stores the value only if the record is still at the version, returns the new version.
+Version zero means the record must not exist. Fails with VersionConflictError otherwise.
+*/
+func CompareAndSwap[T any](bucket Bucket, ID ID128, version int64, value *T) (int64, error) {
+	if bucket.Bucket([]byte(VERSIONS_NESTED_BUCKET)) == nil {
+		return 0, VersionsNotEnabledError{}
+	}
+
+	actual := GetVersion(bucket, ID)
+	if IsExpired(bucket, ID) {
+		actual = 0
+	}
+	if actual != version {
+		return 0, VersionConflictError{ID: ID, Expected: version, Actual: actual}
+	}
+
+	err := Insert(bucket, ID, value)
+	if err != nil {
+		return 0, err
+	}
+	return GetVersion(bucket, ID), nil
+}
+
+// UpdateIfVersion is CompareAndSwap in its own transaction
+func UpdateIfVersion[T any](ctx *Context, bucketID BucketID, ID ID128, version int64, value *T) (newVersion int64, err error) {
+	err = ctx.Database.Update(func(tx Tx) error {
+		bucket, err := GetBucket(tx, bucketID)
+		if err != nil {
+			return err
+		}
+
+		newVersion, err = CompareAndSwap(bucket, ID, version, value)
+		return err
+	})
+	return newVersion, err
+}
+
+/*
+UpdateByID reads the record, lets the procedure change it and stores it if nobody wrote it in the meantime.
+On a conflict it starts over with the fresh record, the procedure may run several times and should have no other effects.
+The procedure runs outside of the write transaction. Fails with RecordNotFoundError if the record doesn't exist.
+*/
+func UpdateByID[T any](ctx *Context, bucketID BucketID, ID ID128, procedure func(value *T) error) error {
+	for attempt := 0; attempt < UPDATE_RETRIES; attempt += 1 {
+		var value T
+		var version int64
+		var found bool
+		err := ctx.Database.View(func(tx Tx) error {
+			bucket, err := GetBucket(tx, bucketID)
+			if err != nil {
+				return err
+			}
+
+			value, version, found, err = GetWithVersion[T](bucket, ID)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if !found {
+			return RecordNotFoundError{Bucket: bucketID, ID: ID}
+		}
+
+		err = procedure(&value)
+		if err != nil {
+			return err
+		}
+
+		_, err = UpdateIfVersion(ctx, bucketID, ID, version, &value)
+		if errors.As(err, &VersionConflictError{}) {
+			continue
+		}
+		return err
+	}
+
+	return fmt.Errorf("Record %v in %v: gave up after %v conflicting updates", ID, bucketID, UPDATE_RETRIES)
+}
@@ -0,0 +1,192 @@
+package easyframework
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type versionsTestRecord struct {
+	Counter int64 `id:"1"`
+}
+
+func newVersionsTestContext(t *testing.T) *Context {
+	t.Helper()
+	ctx := new(Context)
+	err := Initialize(ctx, InitializeParams{Storage: NewMemoryStorage()})
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	err = NewBucket(ctx, "versions_test")
+	if err != nil {
+		t.Fatalf("NewBucket: %v", err)
+	}
+	return ctx
+}
+
+func getTestVersion(t *testing.T, ctx *Context, ID ID128) (record versionsTestRecord, version int64) {
+	t.Helper()
+	err := ctx.Database.View(func(tx Tx) error {
+		var err error
+		record, version, _, err = GetWithVersion[versionsTestRecord](tx.Bucket([]byte("versions_test")), ID)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("GetWithVersion: %v", err)
+	}
+	return record, version
+}
+
+func TestEnableVersions(t *testing.T) {
+	ctx := newVersionsTestContext(t)
+	ID := NewID128()
+	err := InsertByID(ctx, "versions_test", ID, &versionsTestRecord{Counter: 1})
+	if err != nil {
+		t.Fatalf("InsertByID: %v", err)
+	}
+
+	_, err = UpdateIfVersion(ctx, "versions_test", ID, 0, &versionsTestRecord{})
+	if !errors.As(err, &VersionsNotEnabledError{}) {
+		t.Fatalf("Expected VersionsNotEnabledError, got %v", err)
+	}
+
+	err = EnableVersions(ctx, "versions_test")
+	if err != nil {
+		t.Fatalf("EnableVersions: %v", err)
+	}
+	_, first := getTestVersion(t, ctx, ID)
+	if first == 0 {
+		t.Fatalf("Existing record didn't get a version")
+	}
+
+	err = EnableVersions(ctx, "versions_test")
+	if err != nil {
+		t.Fatalf("EnableVersions: %v", err)
+	}
+	if _, version := getTestVersion(t, ctx, ID); version != first {
+		t.Fatalf("Enabling versions again changed the version from %v to %v", first, version)
+	}
+
+	err = InsertByID(ctx, "versions_test", ID, &versionsTestRecord{Counter: 2})
+	if err != nil {
+		t.Fatalf("InsertByID: %v", err)
+	}
+	if _, version := getTestVersion(t, ctx, ID); version <= first {
+		t.Fatalf("Plain insert didn't bump the version, %v after %v", version, first)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	ctx := newVersionsTestContext(t)
+	err := EnableVersions(ctx, "versions_test")
+	if err != nil {
+		t.Fatalf("EnableVersions: %v", err)
+	}
+	ID := NewID128()
+
+	created, err := UpdateIfVersion(ctx, "versions_test", ID, 0, &versionsTestRecord{Counter: 1})
+	if err != nil || created == 0 {
+		t.Fatalf("UpdateIfVersion of a new record returned %v: %v", created, err)
+	}
+	_, err = UpdateIfVersion(ctx, "versions_test", ID, 0, &versionsTestRecord{Counter: 2})
+	var conflict VersionConflictError
+	if !errors.As(err, &conflict) || conflict.Actual != created {
+		t.Fatalf("Creating an existing record returned %v", err)
+	}
+
+	updated, err := UpdateIfVersion(ctx, "versions_test", ID, created, &versionsTestRecord{Counter: 2})
+	if err != nil || updated <= created {
+		t.Fatalf("UpdateIfVersion returned %v: %v", updated, err)
+	}
+	_, err = UpdateIfVersion(ctx, "versions_test", ID, created, &versionsTestRecord{Counter: 3})
+	if !errors.As(err, &conflict) || conflict.Expected != created || conflict.Actual != updated {
+		t.Fatalf("Stale version returned %v", err)
+	}
+	if record, _ := getTestVersion(t, ctx, ID); record.Counter != 2 {
+		t.Fatalf("Conflicting write was stored, counter %v", record.Counter)
+	}
+
+	err = ctx.Database.Update(func(tx Tx) error {
+		return Delete[versionsTestRecord](tx.Bucket([]byte("versions_test")), ID)
+	})
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	recreated, err := UpdateIfVersion(ctx, "versions_test", ID, 0, &versionsTestRecord{Counter: 1})
+	if err != nil || recreated <= updated {
+		t.Fatalf("Recreated record got version %v after %v: %v", recreated, updated, err)
+	}
+}
+
+func TestCompareAndSwapTreatsExpiredAsMissing(t *testing.T) {
+	ctx := newVersionsTestContext(t)
+	err := EnableVersions(ctx, "versions_test")
+	if err != nil {
+		t.Fatalf("EnableVersions: %v", err)
+	}
+	ID := NewID128()
+	err = ctx.Database.Update(func(tx Tx) error {
+		return InsertWithExpiry(tx, "versions_test", ID, &versionsTestRecord{Counter: 1}, time.Now().Add(-time.Second))
+	})
+	if err != nil {
+		t.Fatalf("InsertWithExpiry: %v", err)
+	}
+
+	_, err = UpdateIfVersion(ctx, "versions_test", ID, 0, &versionsTestRecord{Counter: 2})
+	if err != nil {
+		t.Fatalf("Expired record wasn't treated as missing: %v", err)
+	}
+	if record, version := getTestVersion(t, ctx, ID); record.Counter != 2 || version == 0 {
+		t.Fatalf("Got counter %v version %v", record.Counter, version)
+	}
+}
+
+func TestUpdateByIDRetriesOnConflict(t *testing.T) {
+	ctx := newVersionsTestContext(t)
+	err := EnableVersions(ctx, "versions_test")
+	if err != nil {
+		t.Fatalf("EnableVersions: %v", err)
+	}
+	ID := NewID128()
+	err = InsertByID(ctx, "versions_test", ID, &versionsTestRecord{Counter: 1})
+	if err != nil {
+		t.Fatalf("InsertByID: %v", err)
+	}
+
+	runs := 0
+	err = UpdateByID(ctx, "versions_test", ID, func(record *versionsTestRecord) error {
+		runs += 1
+		if runs == 1 {
+			// Another writer gets in between the read and the write
+			err := InsertByID(ctx, "versions_test", ID, &versionsTestRecord{Counter: 10})
+			if err != nil {
+				t.Fatalf("InsertByID: %v", err)
+			}
+		}
+		record.Counter += 1
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateByID: %v", err)
+	}
+	if runs != 2 {
+		t.Fatalf("Procedure ran %v times, expected a retry", runs)
+	}
+	if record, _ := getTestVersion(t, ctx, ID); record.Counter != 11 {
+		t.Fatalf("Update was lost or based on a stale record, counter %v", record.Counter)
+	}
+
+	err = UpdateByID(ctx, "versions_test", NewID128(), func(record *versionsTestRecord) error { return nil })
+	if !errors.As(err, &RecordNotFoundError{}) {
+		t.Fatalf("Expected RecordNotFoundError, got %v", err)
+	}
+
+	runs = 0
+	err = UpdateByID(ctx, "versions_test", ID, func(record *versionsTestRecord) error {
+		runs += 1
+		return InsertByID(ctx, "versions_test", ID, &versionsTestRecord{})
+	})
+	if err == nil || runs != UPDATE_RETRIES {
+		t.Fatalf("Endless conflicts ran %v times and returned %v", runs, err)
+	}
+}